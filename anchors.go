@@ -0,0 +1,133 @@
+package highlighting
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+)
+
+var idAttrName = []byte("id")
+
+// lineAnchorPrefix returns a prefix of line anchors of the given code block.
+// The prefix is derived from an id attribute, a title or an ordinal of the
+// code block and is unique in the document. prefixes holds prefixes of all
+// code blocks in the document returned by lineAnchorPrefixes, or is nil if
+// they are not computed yet.
+func (c *Config) lineAnchorPrefix(prefixes map[ast.Node]string, source []byte, n ast.Node) string {
+	if prefix, ok := prefixes[n]; ok {
+		return prefix
+	}
+	doc := n.OwnerDocument()
+	if doc == nil {
+		return lineAnchorBase(source, n, 1) + "-"
+	}
+	if prefixes == nil {
+		prefixes = c.lineAnchorPrefixes(source, doc)
+		if prefix, ok := prefixes[n]; ok {
+			return prefix
+		}
+	}
+	return lineAnchorBase(source, n, len(prefixes)+1) + "-"
+}
+
+// lineAnchorPrefixes returns prefixes of line anchors of all code blocks in
+// the given document.
+func (c *Config) lineAnchorPrefixes(source []byte, doc ast.Node) map[ast.Node]string {
+	prefixes := map[ast.Node]string{}
+	seen := map[string]int{}
+	ordinal := 0
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		// code blocks never appear in inline elements.
+		if n.Type() == ast.TypeInline {
+			return ast.WalkSkipChildren, nil
+		}
+		if n.Kind() != ast.KindFencedCodeBlock && (!c.IndentedCodeBlocks || n.Kind() != ast.KindCodeBlock) {
+			return ast.WalkContinue, nil
+		}
+		ordinal++
+		base := lineAnchorBase(source, n, ordinal)
+		seen[base]++
+		if count := seen[base]; count > 1 {
+			base += "-" + strconv.Itoa(count)
+		}
+		prefixes[n] = base + "-"
+		return ast.WalkSkipChildren, nil
+	})
+	return prefixes
+}
+
+// lineAnchorBase returns a valid id attribute, a slug of a title or an ordinal of
+// the given code block.
+func lineAnchorBase(source []byte, n ast.Node, ordinal int) string {
+	attrs := codeBlockAttributes(source, n)
+	if attrs != nil {
+		if v, ok := attrs.Get(idAttrName); ok {
+			// ids are written into attributes of chroma's output without
+			// escaping.
+			if id, ok := v.([]byte); ok && isAnchorID(id) {
+				return string(id)
+			}
+		}
+	}
+	if title, ok := codeBlockTitle(attrs); ok {
+		if slug := slugify(string(title)); len(slug) != 0 {
+			return slug
+		}
+	}
+	return "code-" + strconv.Itoa(ordinal)
+}
+
+// isAnchorID returns true if the given id is not empty and consists of
+// letters, digits, hyphens and underscores.
+func isAnchorID(id []byte) bool {
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return len(id) != 0
+}
+
+// slugify converts the given string into a lower-cased string that consists
+// of letters, digits and hyphens.
+func slugify(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(s) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if hyphen && b.Len() != 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(c)
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+const optLineAnchors renderer.OptionName = "HighlightingLineAnchors"
+
+type withLineAnchors struct {
+	value bool
+}
+
+func (o *withLineAnchors) SetConfig(c *renderer.Config) {
+	c.Options[optLineAnchors] = o.value
+}
+
+func (o *withLineAnchors) SetHighlightingOption(c *Config) {
+	c.LineAnchors = o.value
+}
+
+// WithLineAnchors is a functional option that enables linkable line numbers
+// whose anchors are unique in a document.
+func WithLineAnchors(b bool) Option {
+	return &withLineAnchors{b}
+}
@@ -0,0 +1,103 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/text"
+)
+
+func TestHighlightingLineAnchors(t *testing.T) {
+	for i, test := range []struct {
+		source   string
+		expect   []string
+		unexpect []string
+	}{
+		{
+			"```go\nx := 1\n```\n\n```go\nx := 1\n```\n",
+			[]string{`id="code-1-1"`, `href="#code-1-1"`, `id="code-2-1"`},
+			nil,
+		},
+		{
+			"```go {id=\"main\"}\nx := 1\n```\n\n```go {title=\"Hello, World!\"}\nx := 1\n```\n",
+			[]string{`id="main-1"`, `id="hello-world-1"`},
+			nil,
+		},
+		{
+			"```go {title=\"main.go\"}\nx := 1\n```\n\n- item\n\n  ```go {title=\"main.go\"}\n  x := 1\n  ```\n",
+			[]string{`id="main-go-1"`, `id="main-go-2-1"`},
+			nil,
+		},
+		{
+			"```go {id=\"x\\\"><script>alert(1)</script>\" title=\"main.go\"}\nx := 1 // <1>\n```\n1. one\n\n```go {id=\"a b\"}\nx := 1\n```\n",
+			[]string{`id="main-go-1"`, `href="#main-go-callout-1"`, `id="main-go-callout-1"`, `id="code-2-1"`},
+			[]string{"<script>", `"a b`},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(
+						WithLineAnchors(true),
+						WithCallouts(true),
+						WithFormatOptions(
+							chromahtml.WithLineNumbers(true),
+						),
+					),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expect {
+				if !strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should be written, got\n%s", e, buffer.String())
+				}
+			}
+			for _, u := range test.unexpect {
+				if strings.Contains(buffer.String(), u) {
+					t.Errorf("%q should not be written, got\n%s", u, buffer.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHighlightingLineAnchorsMeta(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithLineAnchors(true),
+				WithCallouts(true),
+				WithFormatOptions(
+					chromahtml.WithLineNumbers(true),
+				),
+			),
+		),
+	)
+	source := []byte("```go\nx := 1 // <1>\n```\n1. one\n\n```go\nx := 1\n```\n")
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	if len(doc.OwnerDocument().Meta()) != 0 {
+		t.Errorf("parsing should not modify document metadata, got %v", doc.OwnerDocument().Meta())
+	}
+	var buffer bytes.Buffer
+	if err := markdown.Renderer().Render(&buffer, source, doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{`id="code-1-callout-1"`, `href="#code-1-callout-1"`, `id="code-2-1"`} {
+		if !strings.Contains(buffer.String(), e) {
+			t.Errorf("%q should be written, got\n%s", e, buffer.String())
+		}
+	}
+	if len(doc.OwnerDocument().Meta()) != 0 {
+		t.Errorf("rendering should not modify document metadata, got %v", doc.OwnerDocument().Meta())
+	}
+	if len(markdown.Renderer().(*stateRenderer).r.states) != 0 {
+		t.Error("prefixes of line anchors should be discarded after rendering")
+	}
+}
@@ -0,0 +1,278 @@
+package highlighting
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// Cache is a cache of highlighted code.
+// Keys are hashes of code, lexers, styles and formatter options.
+//
+// Lexers are identified by their types, configs and, for regex lexers, their
+// rules. Behaviour of other lexers, like custom chroma.Lexer
+// implementations, is not part of keys, so Config.CacheSalt must be changed
+// when such lexers are changed and cached values outlive the process.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Cache interface {
+	// Get returns (value, true) if a value associated with the given key
+	// exists, otherwise (nil, false).
+	Get(key string) ([]byte, bool)
+
+	// Set associates the given value with the given key.
+	Set(key string, value []byte)
+}
+
+// cacheKey returns a cache key for the given code and highlighting settings.
+// salt is Config.CacheSalt. settings describes options the extension
+// resolves from attributes of the code block.
+func cacheKey(salt, code string, lexer chroma.Lexer, style *chroma.Style, formatter *chromahtml.Formatter, settings string, d *decorations) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00", salt)
+	_, _ = io.WriteString(h, code)
+	_, _ = fmt.Fprintf(h, "\x00%s\x00%s\x00%s\x00", chromaVersion, lexerKey(lexer), style.Name)
+	types := style.Types()
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, tt := range types {
+		_, _ = fmt.Fprintf(h, "%d:%s;", tt, style.Get(tt))
+	}
+	_, _ = fmt.Fprintf(h, "\x00%s\x00%s", settings, formatterKey(formatter, style, strings.Count(code, "\n")+1))
+	if d != nil {
+		_, _ = fmt.Fprintf(h, "\x00%s", d.key)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// chromaVersion is a version of the chroma module, so values cached by
+// other versions of chroma are not used.
+var chromaVersion = func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range info.Deps {
+			if m.Path == "github.com/alecthomas/chroma/v2" {
+				if m.Replace != nil {
+					return m.Replace.Path + "@" + m.Replace.Version
+				}
+				return m.Version
+			}
+		}
+	}
+	return ""
+}()
+
+// lexerKeys caches keys of lexers that have rules.
+var lexerKeys sync.Map
+
+// lexerKey returns a key that identifies the given lexer by its type,
+// config and rules, so different lexers that have the same name are not
+// confused. Lexers other than regex lexers are identified only by their
+// types and configs.
+func lexerKey(lexer chroma.Lexer) string {
+	if lexer == nil {
+		return ""
+	}
+	regexLexer, isRegexLexer := lexer.(*chroma.RegexLexer)
+	if isRegexLexer {
+		if key, ok := lexerKeys.Load(regexLexer); ok {
+			return key.(string)
+		}
+	}
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%T\x00%+v\x00", lexer, *lexer.Config())
+	switch l := lexer.(type) {
+	case *chroma.RegexLexer:
+		if rules, err := l.Rules(); err == nil {
+			states := make([]string, 0, len(rules))
+			for state := range rules {
+				states = append(states, state)
+			}
+			sort.Strings(states)
+			for _, state := range states {
+				for _, rule := range rules[state] {
+					_, _ = fmt.Fprintf(h, "%s\x00%s\x00", state, rule.Pattern)
+					if tt, ok := rule.Type.(chroma.TokenType); ok {
+						_, _ = fmt.Fprintf(h, "%d\x00", tt)
+					} else {
+						_, _ = fmt.Fprintf(h, "%T\x00", rule.Type)
+					}
+				}
+			}
+		}
+	case *sessionLexer:
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s", lexerKey(l.shell), lexerKey(l.powershell), l.pattern)
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+	if isRegexLexer {
+		lexerKeys.Store(regexLexer, key)
+	}
+	return key
+}
+
+// formatterKey returns a key that identifies options of the given
+// formatter. Options are not exported, so this formats a probe that has the
+// given number of lines and uses the output as the key.
+func formatterKey(formatter *chromahtml.Formatter, style *chroma.Style, lines int) string {
+	tokens := []chroma.Token{
+		{Type: chroma.Keyword, Value: "k"},
+		{Type: chroma.Text, Value: "\t"},
+		{Type: chroma.Name, Value: "n\n"},
+	}
+	for i := 1; i < lines; i++ {
+		tokens = append(tokens, chroma.Token{Type: chroma.Text, Value: "\n"})
+	}
+	h := sha256.New()
+	_ = formatter.Format(h, style, chroma.Literator(tokens...))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+// NewMemoryCache returns a new in-memory Cache that holds at most size
+// values. Least recently used values are evicted first.
+func NewMemoryCache(size int) Cache {
+	return &memoryCache{
+		size:    size,
+		entries: list.New(),
+		index:   map[string]*list.Element{},
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.index[key]
+	if !ok {
+		return nil, false
+	}
+	c.entries.MoveToFront(e)
+	return e.Value.(*memoryCacheEntry).value, true
+}
+
+func (c *memoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.index[key]; ok {
+		e.Value.(*memoryCacheEntry).value = value
+		c.entries.MoveToFront(e)
+		return
+	}
+	c.index[key] = c.entries.PushFront(&memoryCacheEntry{key, value})
+	for c.entries.Len() > c.size {
+		e := c.entries.Back()
+		c.entries.Remove(e)
+		delete(c.index, e.Value.(*memoryCacheEntry).key)
+	}
+}
+
+type fileCache struct {
+	dir string
+}
+
+// NewFileCache returns a new Cache that stores values as files under the
+// given directory. Values can be reused across processes, so
+// Config.CacheSalt should be changed when custom lexers are changed.
+// Errors on reading and writing files are ignored.
+func NewFileCache(dir string) Cache {
+	return &fileCache{
+		dir: dir,
+	}
+}
+
+func (c *fileCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+func (c *fileCache) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *fileCache) Set(key string, value []byte) {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	// write to a temporary file first so that readers never see a
+	// partially written value.
+	f, err := ioutil.TempFile(filepath.Dir(path), key+".*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+	}
+}
+
+const optCache renderer.OptionName = "HighlightingCache"
+
+type withCache struct {
+	value Cache
+}
+
+func (o *withCache) SetConfig(c *renderer.Config) {
+	c.Options[optCache] = o.value
+}
+
+func (o *withCache) SetHighlightingOption(c *Config) {
+	c.Cache = o.value
+}
+
+// WithCache is a functional option that sets a Cache of highlighted code.
+func WithCache(c Cache) Option {
+	return &withCache{c}
+}
+
+const optCacheSalt renderer.OptionName = "HighlightingCacheSalt"
+
+type withCacheSalt struct {
+	value string
+}
+
+func (o *withCacheSalt) SetConfig(c *renderer.Config) {
+	c.Options[optCacheSalt] = o.value
+}
+
+func (o *withCacheSalt) SetHighlightingOption(c *Config) {
+	c.CacheSalt = o.value
+}
+
+// WithCacheSalt is a functional option that sets a string added to keys of
+// the Cache. Changing the salt, for example to a version of custom lexers,
+// invalidates values cached with other salts.
+func WithCacheSalt(salt string) Option {
+	return &withCacheSalt{salt}
+}
@@ -0,0 +1,168 @@
+package highlighting
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark"
+)
+
+type countingLexer struct {
+	chroma.Lexer
+	count int
+}
+
+func (l *countingLexer) Tokenise(options *chroma.TokeniseOptions, text string) (chroma.Iterator, error) {
+	l.count++
+	return l.Lexer.Tokenise(options, text)
+}
+
+func TestHighlightingCache(t *testing.T) {
+	for _, cache := range []Cache{NewMemoryCache(10), NewFileCache(t.TempDir())} {
+		lexer := &countingLexer{Lexer: lexers.Get("go")}
+		markdown := goldmark.New(
+			goldmark.WithExtensions(
+				NewHighlighting(
+					WithCache(cache),
+					WithLanguageAliasLexer("countinggo", lexer),
+					WithFormatOptions(
+						chromahtml.WithClasses(true),
+					),
+				),
+			),
+		)
+		source := []byte("```countinggo\nx := 1\n```\n\n```countinggo\nx := 1\n```\n\n```countinggo {linenos=true}\nx := 1\n```\n")
+		var buffer1, buffer2 bytes.Buffer
+		if err := markdown.Convert(source, &buffer1); err != nil {
+			t.Fatal(err)
+		}
+		if lexer.count != 2 {
+			t.Errorf("identical code should be tokenized once, got %d", lexer.count)
+		}
+		if err := markdown.Convert(source, &buffer2); err != nil {
+			t.Fatal(err)
+		}
+		if lexer.count != 2 {
+			t.Errorf("cached code should not be tokenized, got %d", lexer.count)
+		}
+		if buffer1.String() != buffer2.String() {
+			t.Errorf("cached output differs\n%s\n%s", buffer1.String(), buffer2.String())
+		}
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", []byte("A"))
+	cache.Set("b", []byte("B"))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	cache.Set("c", []byte("C"))
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should be evicted as the least recently used value")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%s should be cached", key)
+		}
+	}
+}
+
+func TestHighlightingCacheKey(t *testing.T) {
+	fakeGo := chroma.MustNewLexer(&chroma.Config{Name: "Go"}, func() chroma.Rules {
+		return chroma.Rules{
+			"root": {
+				{Pattern: `[^\n]+`, Type: chroma.Comment},
+				{Pattern: `\n`, Type: chroma.Text},
+			},
+		}
+	})
+	for i, options := range [][]Option{
+		{WithLanguageAliasLexer("go", fakeGo)},
+		{WithFormatOptions(chromahtml.WithLineNumbers(true))},
+		{WithCodeBlockOptions(func(c CodeBlockContext) []chromahtml.Option {
+			return []chromahtml.Option{chromahtml.HighlightLines([][2]int{{3, 3}})}
+		})},
+	} {
+		cache := NewMemoryCache(10)
+		source := []byte("```go\nx := 1\ny := 2\nz := 3\n```\n")
+		var buffer1, buffer2 bytes.Buffer
+		if err := goldmark.New(goldmark.WithExtensions(NewHighlighting(WithCache(cache)))).Convert(source, &buffer1); err != nil {
+			t.Fatal(err)
+		}
+		if err := goldmark.New(goldmark.WithExtensions(NewHighlighting(append(options, WithCache(cache))...))).Convert(source, &buffer2); err != nil {
+			t.Fatal(err)
+		}
+		if buffer1.String() == buffer2.String() {
+			t.Errorf("%d: different settings should not share cached output, got\n%s", i, buffer2.String())
+		}
+	}
+}
+
+// commentLexer is a lexer whose behaviour changes without changing its
+// config.
+type commentLexer struct {
+	chroma.Lexer
+	comment bool
+}
+
+func (l *commentLexer) Tokenise(options *chroma.TokeniseOptions, text string) (chroma.Iterator, error) {
+	if l.comment {
+		return chroma.Literator(chroma.Token{Type: chroma.Comment, Value: text}), nil
+	}
+	return l.Lexer.Tokenise(options, text)
+}
+
+func TestHighlightingCacheSalt(t *testing.T) {
+	cache := NewFileCache(t.TempDir())
+	source := []byte("```custom\nx := 1\n```\n")
+	convert := func(comment bool, salt string) string {
+		var buffer bytes.Buffer
+		markdown := goldmark.New(
+			goldmark.WithExtensions(
+				NewHighlighting(
+					WithCache(cache),
+					WithCacheSalt(salt),
+					WithLanguageAliasLexer("custom", &commentLexer{lexers.Get("go"), comment}),
+				),
+			),
+		)
+		if err := markdown.Convert(source, &buffer); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.String()
+	}
+	v1 := convert(false, "v1")
+	if convert(true, "v1") != v1 {
+		t.Error("changes of custom lexers should not be detected without changing the salt")
+	}
+	if convert(true, "v2") == v1 {
+		t.Error("a different salt should not share cached output")
+	}
+}
+
+type testPreWrapper struct {
+	class string
+}
+
+func (p *testPreWrapper) Start(code bool, styleAttr string) string {
+	return `<pre class="` + p.class + `">`
+}
+
+func (p *testPreWrapper) End(code bool) string {
+	return "</pre>"
+}
+
+func TestCacheKeyStable(t *testing.T) {
+	key := func() string {
+		formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithPreWrapper(&testPreWrapper{"code"}))
+		return cacheKey("", "x := 1\n", lexers.Get("go"), chroma.MustNewStyle("test", chroma.StyleEntries{}), formatter, "", nil)
+	}
+	if key() != key() {
+		t.Error("cache keys should not depend on addresses of options")
+	}
+}
@@ -0,0 +1,261 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultCalloutPattern is a default regular expression of callout markers.
+// This matches markers like `// <1>`, `# <1> <2>` and `<!-- <1> -->` at
+// ends of lines.
+var DefaultCalloutPattern = regexp.MustCompile(`\s*(?://|#|--|;|%|<!--)\s*((?:<\d+>\s*)+)(?:-->)?\s*$`)
+
+var calloutNumberPattern = regexp.MustCompile(`\d+`)
+
+// calloutPattern returns a regular expression of callout markers for the
+// given language.
+func (c *Config) calloutPattern(language []byte) *regexp.Regexp {
+	if c.CalloutPatterns != nil {
+		if p, ok := c.CalloutPatterns[strings.ToLower(string(language))]; ok {
+			return p
+		}
+	}
+	return DefaultCalloutPattern
+}
+
+// stripCallouts strips callout markers from lines of the given code.
+// This returns the stripped code and numbers of callouts per line.
+func stripCallouts(code string, pattern *regexp.Regexp) (string, map[int][]int) {
+	var b strings.Builder
+	var callouts map[int][]int
+	for i, line := range strings.SplitAfter(code, "\n") {
+		content := strings.TrimSuffix(line, "\n")
+		m := pattern.FindStringSubmatchIndex(content)
+		if m == nil {
+			b.WriteString(line)
+			continue
+		}
+		markers := content[m[0]:m[1]]
+		if len(m) > 3 && m[2] > -1 {
+			markers = content[m[2]:m[3]]
+		}
+		var numbers []int
+		for _, s := range calloutNumberPattern.FindAllString(markers, -1) {
+			if number, err := strconv.Atoi(s); err == nil {
+				numbers = append(numbers, number)
+			}
+		}
+		if len(numbers) == 0 {
+			b.WriteString(line)
+			continue
+		}
+		if callouts == nil {
+			callouts = map[int][]int{}
+		}
+		callouts[i] = numbers
+		b.WriteString(content[:m[0]])
+		b.WriteString(line[len(content):])
+	}
+	return b.String(), callouts
+}
+
+// calloutID returns an id of the list item that describes a callout.
+func calloutID(prefix string, number int) string {
+	return prefix + "callout-" + strconv.Itoa(number)
+}
+
+// calloutList returns an ordered list that describes callouts of the given
+// code block.
+func calloutList(n ast.Node) *ast.List {
+	if list, ok := n.NextSibling().(*ast.List); ok && list.IsOrdered() {
+		return list
+	}
+	return nil
+}
+
+// addCalloutDecorations adds decorations that render callout badges at ends
+// of lines. If linked is true, badges link to items of the ordered list
+// that follows the code block.
+func addCalloutDecorations(d *decorations, callouts map[int][]int, prefix string, linked bool) {
+	lines := make([]int, 0, len(callouts))
+	for line := range callouts {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	var key strings.Builder
+	_, _ = fmt.Fprintf(&key, "callouts:%s:%t", prefix, linked)
+	for _, line := range lines {
+		_, _ = fmt.Fprintf(&key, ":%d=%v", line, callouts[line])
+	}
+	d.add(key.String(), func(d *decorations, tokens [][]chroma.Token) [][]chroma.Token {
+		for _, line := range lines {
+			if line >= len(tokens) {
+				break
+			}
+			var markup strings.Builder
+			for _, number := range callouts[line] {
+				markup.WriteString(calloutBadge(prefix, number, linked))
+			}
+			tokens[line] = appendToLine(tokens[line], d.placeholder(decoration{markup: markup.String()}))
+		}
+		return tokens
+	})
+	d.addCSS(calloutCSS)
+}
+
+// calloutBadge returns markup of a callout badge.
+func calloutBadge(prefix string, number int, linked bool) string {
+	if linked {
+		return fmt.Sprintf(`<a class="callout" href="#%s" aria-label="Callout %d">%d</a>`,
+			util.EscapeHTML([]byte(calloutID(prefix, number))), number, number)
+	}
+	return fmt.Sprintf(`<span class="callout" aria-label="Callout %d">%d</span>`, number, number)
+}
+
+// calloutCSS returns CSS data for callout badges.
+func calloutCSS(prefix string, style *chroma.Style) string {
+	color := ""
+	if entry := style.Get(chroma.LineNumbers); entry.Colour.IsSet() {
+		color = "color: " + entry.Colour.String() + "; "
+	}
+	return fmt.Sprintf("/* Callout */ .%schroma .callout { %sborder: 1px solid currentColor; border-radius: 1em; padding: 0 0.4em; margin-left: 0.4em; text-decoration: none; user-select: none }\n",
+		prefix, color)
+}
+
+// calloutTransformer sets ids to items of ordered lists that describe
+// callouts of preceding code blocks, so callout badges can link to them.
+type calloutTransformer struct {
+	config *Config
+}
+
+var calloutListClass = []byte("callouts")
+
+// Transform implements parser.ASTTransformer.
+func (t *calloutTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var blocks []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if n.Type() == ast.TypeInline {
+			return ast.WalkSkipChildren, nil
+		}
+		if n.Kind() == ast.KindFencedCodeBlock || (t.config.IndentedCodeBlocks && n.Kind() == ast.KindCodeBlock) {
+			blocks = append(blocks, n)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	var prefixes map[ast.Node]string
+	for _, n := range blocks {
+		list := calloutList(n)
+		if list == nil || !t.hasCallouts(source, n) {
+			continue
+		}
+		if prefixes == nil {
+			prefixes = t.config.lineAnchorPrefixes(source, doc)
+		}
+		prefix := t.config.lineAnchorPrefix(prefixes, source, n)
+		class := calloutListClass
+		if v, ok := list.AttributeString("class"); ok {
+			if c, ok := v.([]byte); ok {
+				class = append(append(append([]byte{}, c...), ' '), calloutListClass...)
+			}
+		}
+		list.SetAttributeString("class", class)
+		number := list.Start
+		if number == 0 {
+			number = 1
+		}
+		for c := list.FirstChild(); c != nil; c = c.NextSibling() {
+			c.SetAttributeString("id", []byte(calloutID(prefix, number)))
+			number++
+		}
+	}
+}
+
+// hasCallouts returns true if the given code block has callout markers.
+func (t *calloutTransformer) hasCallouts(source []byte, n ast.Node) bool {
+	var language []byte
+	if fcb, ok := n.(*ast.FencedCodeBlock); ok {
+		language = fencedCodeBlockLanguage(source, fcb)
+	} else {
+		language = []byte(t.config.IndentedCodeLanguage)
+	}
+	if lang, ok := t.config.diffLanguage(language); ok {
+		language = lang
+	}
+	pattern := t.config.calloutPattern(language)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		if pattern.Match(bytes.TrimRight(line.Value(source), "\n")) {
+			return true
+		}
+	}
+	return false
+}
+
+const optCallouts renderer.OptionName = "HighlightingCallouts"
+
+type withCallouts struct {
+	value bool
+}
+
+func (o *withCallouts) SetConfig(c *renderer.Config) {
+	c.Options[optCallouts] = o.value
+}
+
+func (o *withCallouts) SetHighlightingOption(c *Config) {
+	c.Callouts = o.value
+}
+
+// WithCallouts is a functional option that enables callout markers like
+// `// <1>` at ends of lines. Markers are removed from highlighted code and
+// rendered as badges that link to items of an ordered list following the
+// code block.
+func WithCallouts(b bool) Option {
+	return &withCallouts{b}
+}
+
+const optCalloutPatterns renderer.OptionName = "HighlightingCalloutPatterns"
+
+type withCalloutPattern struct {
+	language string
+	value    *regexp.Regexp
+}
+
+func (o *withCalloutPattern) SetConfig(c *renderer.Config) {
+	if _, ok := c.Options[optCalloutPatterns]; !ok {
+		c.Options[optCalloutPatterns] = map[string]*regexp.Regexp{}
+	}
+	c.Options[optCalloutPatterns].(map[string]*regexp.Regexp)[strings.ToLower(o.language)] = o.value
+}
+
+func (o *withCalloutPattern) SetHighlightingOption(c *Config) {
+	if c.CalloutPatterns == nil {
+		c.CalloutPatterns = map[string]*regexp.Regexp{}
+	}
+	c.CalloutPatterns[strings.ToLower(o.language)] = o.value
+}
+
+// WithCalloutPattern is a functional option that sets a regular expression
+// of callout markers for the given language. The pattern should match
+// markers at ends of lines. If the pattern has a submatch, numbers of
+// callouts are taken from the submatch, otherwise from the whole match.
+// The language is case-insensitive.
+func WithCalloutPattern(language string, pattern *regexp.Regexp) Option {
+	return &withCalloutPattern{language, pattern}
+}
@@ -0,0 +1,72 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingCallouts(t *testing.T) {
+	for i, test := range []struct {
+		options  []Option
+		source   string
+		expect   []string
+		unexpect []string
+	}{
+		{
+			nil,
+			"```go\nx := 1 // <1>\ny := 2 // <2> <3>\n```\n\n1. x\n2. y\n3. z\n",
+			[]string{
+				`<span class="mi">1</span><a class="callout" href="#code-1-callout-1" aria-label="Callout 1">1</a>` + "\n",
+				`<a class="callout" href="#code-1-callout-2" aria-label="Callout 2">2</a><a class="callout" href="#code-1-callout-3" aria-label="Callout 3">3</a>`,
+				`<ol class="callouts">` + "\n" + `<li id="code-1-callout-1">x</li>`,
+				`<li id="code-1-callout-3">z</li>`,
+			},
+			[]string{"&lt;1&gt;", "//"},
+		},
+		{
+			nil,
+			"```python {id=\"example\"}\nx = 1  # <1>\n```\n",
+			[]string{`<span class="mi">1</span><span class="callout" aria-label="Callout 1">1</span>` + "\n"},
+			[]string{"#", "<ol"},
+		},
+		{
+			[]Option{WithCalloutPattern("go", regexp.MustCompile(`\s*/\*\s*\((\d+)\)\s*\*/$`))},
+			"```go\nx := 1 /* (1) */\ny := 2 // <2>\n```\n",
+			[]string{`<span class="callout" aria-label="Callout 1">1</span>`, "&lt;2&gt;"},
+			[]string{"(1)"},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(append([]Option{
+						WithCallouts(true),
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					}, test.options...)...),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expect {
+				if !strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should be written, got\n%s", e, buffer.String())
+				}
+			}
+			for _, e := range test.unexpect {
+				if strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should not be written, got\n%s", e, buffer.String())
+				}
+			}
+		})
+	}
+}
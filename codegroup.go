@@ -0,0 +1,283 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var groupAttrName = []byte("group")
+
+// KindCodeGroup is a NodeKind of the CodeGroup node.
+var KindCodeGroup = ast.NewNodeKind("CodeGroup")
+
+// A CodeGroup struct represents consecutive fenced code blocks that have
+// the same group attribute. Code blocks in a group are rendered as tabs.
+type CodeGroup struct {
+	ast.BaseBlock
+
+	// Name is a name of the group.
+	Name []byte
+
+	// Index is a 1-based index of the group in the document.
+	Index int
+}
+
+// Dump implements Node.Dump.
+func (n *CodeGroup) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Name":  string(n.Name),
+		"Index": strconv.Itoa(n.Index),
+	}, nil)
+}
+
+// Kind implements Node.Kind.
+func (n *CodeGroup) Kind() ast.NodeKind {
+	return KindCodeGroup
+}
+
+// NewCodeGroup returns a new CodeGroup node.
+func NewCodeGroup(name []byte, index int) *CodeGroup {
+	return &CodeGroup{
+		Name:  name,
+		Index: index,
+	}
+}
+
+// codeGroupTransformer groups consecutive fenced code blocks that have the
+// same group attribute into CodeGroup nodes.
+type codeGroupTransformer struct {
+}
+
+var defaultCodeGroupTransformer = &codeGroupTransformer{}
+
+// Transform implements parser.ASTTransformer.
+func (t *codeGroupTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var parents []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if n.Type() == ast.TypeInline {
+			return ast.WalkSkipChildren, nil
+		}
+		if n.Kind() == ast.KindFencedCodeBlock && codeGroupName(source, n) != nil {
+			parents = append(parents, n.Parent())
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	index := 0
+	seen := map[ast.Node]bool{}
+	for _, parent := range parents {
+		if seen[parent] {
+			continue
+		}
+		seen[parent] = true
+		var group *CodeGroup
+		for c := parent.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			name := codeGroupName(source, c)
+			if name == nil {
+				group = nil
+				c = next
+				continue
+			}
+			if group == nil || !bytes.Equal(group.Name, name) {
+				index++
+				group = NewCodeGroup(name, index)
+				parent.InsertBefore(parent, c, group)
+			}
+			parent.RemoveChild(parent, c)
+			group.AppendChild(group, c)
+			c = next
+		}
+	}
+}
+
+// codeGroupName returns a value of a group attribute of the given fenced
+// code block, or nil if the node is not a fenced code block in a group.
+func codeGroupName(source []byte, n ast.Node) []byte {
+	if n.Kind() != ast.KindFencedCodeBlock {
+		return nil
+	}
+	attrs := codeBlockAttributes(source, n)
+	if attrs == nil {
+		return nil
+	}
+	if v, ok := attrs.Get(groupAttrName); ok {
+		if name, ok := v.([]byte); ok && len(name) != 0 {
+			return name
+		}
+	}
+	return nil
+}
+
+// codeGroupID returns an id of the k-th tab of the given group.
+func codeGroupID(n *CodeGroup, k int) string {
+	return "code-group-" + strconv.Itoa(n.Index) + "-" + strconv.Itoa(k)
+}
+
+// codeGroupLabel returns a label of a tab of the given code block. This is
+// a title, a language name or an index of the code block.
+func (r *HTMLRenderer) codeGroupLabel(source []byte, n ast.Node, k int) []byte {
+	attrs := codeBlockAttributes(source, n)
+	if title, ok := codeBlockTitle(attrs); ok {
+		return title
+	}
+	if fcb, ok := n.(*ast.FencedCodeBlock); ok {
+		if language := fencedCodeBlockLanguage(source, fcb); language != nil {
+			if lang, ok := r.diffLanguage(language); ok {
+				language = lang
+			}
+			if lexer := r.getLexer(language); lexer != nil {
+				return []byte(lexer.Config().Name)
+			}
+			return language
+		}
+	}
+	return []byte("Code " + strconv.Itoa(k))
+}
+
+func (r *HTMLRenderer) renderCodeGroup(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*CodeGroup)
+	if !entering {
+		_, _ = w.WriteString("</div>\n")
+		return ast.WalkContinue, nil
+	}
+	_, _ = w.WriteString(`<div class="code-group">` + "\n")
+	k := 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		k++
+		id := codeGroupID(n, k)
+		_, _ = fmt.Fprintf(w, `<input type="radio" class="code-group-radio code-group-radio-%d" name="code-group-%d" id="%s"`, k, n.Index, id)
+		if k == 1 {
+			_, _ = w.WriteString(" checked")
+		}
+		_, _ = w.WriteString(">\n")
+	}
+	_, _ = w.WriteString(`<div class="code-group-tabs" role="tablist">` + "\n")
+	k = 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		k++
+		id := codeGroupID(n, k)
+		_, _ = fmt.Fprintf(w, `<label class="code-group-tab code-group-tab-%d" for="%s" id="%s-tab" role="tab" aria-controls="%s-panel" aria-selected="%t">`, k, id, id, id, k == 1)
+		_, _ = w.Write(util.EscapeHTML(r.codeGroupLabel(source, c, k)))
+		_, _ = w.WriteString("</label>\n")
+	}
+	_, _ = w.WriteString("</div>\n")
+	if err := r.writeCodeGroupCSS(k); err != nil {
+		if err := r.handleError(newHighlightError(opWriteCSS, nil, codeBlockLine(source, n.FirstChild()), err)); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+// renderCodeGroupPanel renders a start tag of a tab panel of the given code
+// block in a group.
+func (r *HTMLRenderer) renderCodeGroupPanel(w util.BufWriter, group *CodeGroup, n ast.Node) {
+	k := 1
+	for c := group.FirstChild(); c != nil && c != n; c = c.NextSibling() {
+		k++
+	}
+	id := codeGroupID(group, k)
+	_, _ = fmt.Fprintf(w, `<div class="code-group-panel code-group-panel-%d" id="%s-panel" role="tabpanel" aria-labelledby="%s-tab" tabindex="0">`+"\n", k, id, id)
+}
+
+// CodeGroupScript is a script that makes tabs of code groups follow the ARIA
+// tab pattern. This updates aria-selected attributes of tabs when tabs are
+// switched, moves keyboard focus from radio buttons to tabs and switches
+// tabs with arrow keys, Home and End.
+const CodeGroupScript = `document.querySelectorAll(".code-group").forEach(function (group) {
+  var radios = group.querySelectorAll(":scope > .code-group-radio");
+  var tabs = group.querySelectorAll(":scope > .code-group-tabs > [role=tab]");
+  var update = function () {
+    tabs.forEach(function (tab, i) {
+      tab.setAttribute("aria-selected", radios[i].checked ? "true" : "false");
+      tab.tabIndex = radios[i].checked ? 0 : -1;
+    });
+  };
+  radios.forEach(function (radio) {
+    radio.tabIndex = -1;
+    radio.setAttribute("aria-hidden", "true");
+    radio.addEventListener("change", update);
+  });
+  tabs.forEach(function (tab, i) {
+    tab.addEventListener("keydown", function (e) {
+      var j = {ArrowLeft: i - 1, ArrowRight: i + 1, Home: 0, End: tabs.length - 1}[e.key];
+      if (j === undefined) {
+        return;
+      }
+      j = (j + tabs.length) % tabs.length;
+      radios[j].checked = true;
+      update();
+      tabs[j].focus();
+      e.preventDefault();
+    });
+  });
+  update();
+});
+`
+
+// codeGroupCSS returns CSS data that shows a tab panel of a checked radio
+// button in code groups that have the given number of tabs.
+// If CSS data is not available, all tab panels are shown.
+func codeGroupCSS(tabs int) string {
+	var b bytes.Buffer
+	b.WriteString("/* CodeGroup */ .code-group-radio { position: absolute; opacity: 0; pointer-events: none }\n")
+	b.WriteString("/* CodeGroup */ .code-group-tab { display: inline-block; padding: 0.2em 0.8em; cursor: pointer; border-bottom: 2px solid transparent }\n")
+	b.WriteString("/* CodeGroup */ .code-group-panel { display: none }\n")
+	for k := 1; k <= tabs; k++ {
+		fmt.Fprintf(&b, "/* CodeGroup */ .code-group-radio-%[1]d:checked ~ .code-group-panel-%[1]d { display: block }\n", k)
+		fmt.Fprintf(&b, "/* CodeGroup */ .code-group-radio-%[1]d:checked ~ .code-group-tabs .code-group-tab-%[1]d { border-bottom-color: currentColor }\n", k)
+		fmt.Fprintf(&b, "/* CodeGroup */ .code-group-radio-%[1]d:focus-visible ~ .code-group-tabs .code-group-tab-%[1]d { outline: 2px solid }\n", k)
+	}
+	return b.String()
+}
+
+// writeCodeGroupCSS writes CSS data for code groups that have the given
+// number of tabs.
+func (r *HTMLRenderer) writeCodeGroupCSS(tabs int) error {
+	css := codeGroupCSS(tabs)
+	if r.CSSWriter != nil {
+		if _, err := io.WriteString(r.CSSWriter, css); err != nil {
+			return err
+		}
+	}
+	if r.StyleSheet != nil {
+		r.StyleSheet.AddCSS(css)
+	}
+	return nil
+}
+
+const optCodeGroups renderer.OptionName = "HighlightingCodeGroups"
+
+type withCodeGroups struct {
+	value bool
+}
+
+func (o *withCodeGroups) SetConfig(c *renderer.Config) {
+	c.Options[optCodeGroups] = o.value
+}
+
+func (o *withCodeGroups) SetHighlightingOption(c *Config) {
+	c.CodeGroups = o.value
+}
+
+// WithCodeGroups is a functional option that enables code groups.
+// Consecutive fenced code blocks that have the same group attribute like
+// {group="client"} are rendered as tabs. Tabs are switched by radio buttons
+// and CSS without scripts. Pages should include CodeGroupScript to keep
+// ARIA states of tabs up to date and to switch tabs with arrow keys.
+func WithCodeGroups(b bool) Option {
+	return &withCodeGroups{b}
+}
@@ -0,0 +1,59 @@
+package highlighting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingCodeGroups(t *testing.T) {
+	var css bytes.Buffer
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithCodeGroups(true),
+				WithCSSWriter(&css),
+				WithFormatOptions(
+					chromahtml.WithClasses(true),
+				),
+			),
+		),
+	)
+	source := "```go {group=\"client\"}\nx := 1\n```\n" +
+		"```python {group=\"client\" title=\"client.py\"}\nx = 1\n```\n\n" +
+		"```go\nx := 1\n```\n\n" +
+		"```sh {group=\"server\"}\necho 1\n```\n"
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	output := buffer.String()
+	for _, e := range []string{
+		`<div class="code-group">
+<input type="radio" class="code-group-radio code-group-radio-1" name="code-group-1" id="code-group-1-1" checked>
+<input type="radio" class="code-group-radio code-group-radio-2" name="code-group-1" id="code-group-1-2">
+<div class="code-group-tabs" role="tablist">
+<label class="code-group-tab code-group-tab-1" for="code-group-1-1" id="code-group-1-1-tab" role="tab" aria-controls="code-group-1-1-panel" aria-selected="true">Go</label>
+<label class="code-group-tab code-group-tab-2" for="code-group-1-2" id="code-group-1-2-tab" role="tab" aria-controls="code-group-1-2-panel" aria-selected="false">client.py</label>
+</div>
+<div class="code-group-panel code-group-panel-1" id="code-group-1-1-panel" role="tabpanel" aria-labelledby="code-group-1-1-tab" tabindex="0">
+<pre tabindex="0" class="chroma">`,
+		`<div class="code-group-panel code-group-panel-2" id="code-group-1-2-panel" role="tabpanel" aria-labelledby="code-group-1-2-tab" tabindex="0">
+<pre tabindex="0" class="chroma"><code><span class="line"><span class="cl"><span class="n">x</span>`,
+		`id="code-group-2-1-tab"`,
+		`id="code-group-2-1"`,
+	} {
+		if !strings.Contains(output, e) {
+			t.Errorf("%q should be written, got\n%s", e, output)
+		}
+	}
+	if strings.Count(output, `<div class="code-group">`) != 2 {
+		t.Errorf("blocks without a group should not be grouped, got\n%s", output)
+	}
+	if !strings.Contains(css.String(), "/* CodeGroup */ .code-group-radio-2:checked ~ .code-group-panel-2 { display: block }\n") {
+		t.Errorf("CSS for code groups should be written, got\n%s", css.String())
+	}
+}
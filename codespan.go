@@ -0,0 +1,173 @@
+package highlighting
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+)
+
+var langAttrName = []byte("lang")
+var codeSpanLanguageAttrName = []byte("language")
+
+// codeSpanTransformer moves a language hint that immediately follows
+// a code span(like `code`{:go} or `code`{lang=go}) into attributes of
+// the code span.
+type codeSpanTransformer struct {
+}
+
+var defaultCodeSpanTransformer = &codeSpanTransformer{}
+
+// Transform implements parser.ASTTransformer.
+func (t *codeSpanTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var spans []*ast.CodeSpan
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if cs, ok := n.(*ast.CodeSpan); ok && entering {
+			spans = append(spans, cs)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, cs := range spans {
+		next, ok := cs.NextSibling().(*ast.Text)
+		if !ok {
+			continue
+		}
+		value := next.Segment.Value(source)
+		attrs, consumed := parseCodeSpanHint(value)
+		if consumed == 0 {
+			continue
+		}
+		for _, attr := range attrs {
+			cs.SetAttribute(attr.Name, attr.Value)
+		}
+		if consumed == len(value) && !next.SoftLineBreak() && !next.HardLineBreak() {
+			next.Parent().RemoveChild(next.Parent(), next)
+		} else {
+			next.Segment = next.Segment.WithStart(next.Segment.Start + consumed)
+		}
+	}
+}
+
+// parseCodeSpanHint parses a language hint at the beginning of the given
+// value and returns attributes and the number of consumed bytes.
+func parseCodeSpanHint(value []byte) (parser.Attributes, int) {
+	if len(value) == 0 || value[0] != '{' {
+		return nil, 0
+	}
+	if len(value) > 2 && value[1] == ':' {
+		end := bytes.IndexByte(value, '}')
+		if end < 0 {
+			return nil, 0
+		}
+		lang := bytes.TrimSpace(value[2:end])
+		if len(lang) == 0 || bytes.ContainsAny(lang, " \t{") {
+			return nil, 0
+		}
+		return parser.Attributes{{Name: codeSpanLanguageAttrName, Value: lang}}, end + 1
+	}
+	reader := text.NewReader(value)
+	attrs, ok := parser.ParseAttributes(reader)
+	if !ok {
+		return nil, 0
+	}
+	var result parser.Attributes
+	hasLang := false
+	for _, attr := range attrs {
+		if bytes.Equal(attr.Name, langAttrName) {
+			if v, ok := attr.Value.([]byte); ok {
+				result = append(result, parser.Attribute{Name: codeSpanLanguageAttrName, Value: v})
+				hasLang = true
+			}
+			continue
+		}
+		result = append(result, attr)
+	}
+	if !hasLang {
+		return nil, 0
+	}
+	_, pos := reader.Position()
+	return result, pos.Start
+}
+
+func (r *HTMLRenderer) renderCodeSpan(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.CodeSpan)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var buffer bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		value := c.(*ast.Text).Segment.Value(source)
+		if bytes.HasSuffix(value, []byte("\n")) {
+			buffer.Write(value[:len(value)-1])
+			buffer.WriteByte(' ')
+		} else {
+			buffer.Write(value)
+		}
+	}
+
+	var lexer chroma.Lexer
+	var language []byte
+	if v, ok := n.Attribute(codeSpanLanguageAttrName); ok {
+		if lang, ok := v.([]byte); ok {
+			language = lang
+			lexer = r.getLexer(language)
+			if lexer == nil {
+				r.reportUnknownLanguage(language, codeSpanLine(source, n))
+			}
+		}
+	}
+	if lexer != nil {
+		style := r.defaultStyle()
+		if styleAttr, ok := n.Attribute(styleAttrName); ok {
+			if st, ok := styleAttr.([]byte); ok {
+				style = r.getStyle(string(st))
+			}
+		}
+		chromaFormatterOptions := r.scopeOptions(style)
+		chromaFormatterOptions = append(chromaFormatterOptions, r.FormatOptions...)
+		chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.InlineCode(true))
+		formatter := chromahtml.New(chromaFormatterOptions...)
+		highlighted, err := r.highlight(buffer.String(), lexer, style, formatter, "", nil)
+		if err == nil || (err.Op == opFormat && r.ErrorPolicy == ErrorPolicyIgnore) {
+			_, _ = w.Write(highlighted)
+			if err := r.writeCSS(formatter, style, nil); err != nil {
+				if err := r.handleError(newHighlightError(opWriteCSS, language, codeSpanLine(source, n), err)); err != nil {
+					return ast.WalkStop, err
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		err.Language = language
+		err.Line = codeSpanLine(source, n)
+		if err := r.handleError(err); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+
+	if n.Attributes() != nil {
+		_, _ = w.WriteString("<code")
+		html.RenderAttributes(w, n, html.CodeAttributeFilter)
+		_ = w.WriteByte('>')
+	} else {
+		_, _ = w.WriteString("<code>")
+	}
+	r.Writer.RawWrite(w, buffer.Bytes())
+	_, _ = w.WriteString("</code>")
+	return ast.WalkSkipChildren, nil
+}
+
+// codeSpanLine returns a 1-based line number of the given code span.
+func codeSpanLine(source []byte, n *ast.CodeSpan) int {
+	if t, ok := n.FirstChild().(*ast.Text); ok {
+		return lineAt(source, t.Segment.Start)
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingCodeSpans(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithCodeSpans(true),
+				WithFormatOptions(
+					chromahtml.WithClasses(true),
+				),
+			),
+		),
+	)
+
+	for i, test := range []struct {
+		source string
+		expect string
+	}{
+		{"call `fmt.Println(1)`{:go} here", `<p>call <code class="chroma"><span class="nx">fmt</span><span class="p">.</span><span class="nf">Println</span><span class="p">(</span><span class="mi">1</span><span class="p">)</span></code> here</p>`},
+		{"call `x := 1`{lang=go}", `<p>call <code class="chroma"><span class="nx">x</span> <span class="o">:=</span> <span class="mi">1</span></code></p>`},
+		{"call `x := 1`{lang=unknown}", `<p>call <code>x := 1</code></p>`},
+		{"call `x := 1`{.go}", `<p>call <code>x := 1</code>{.go}</p>`},
+		{"call `x := 1`", `<p>call <code>x := 1</code></p>`},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(buffer.String()) != test.expect {
+				t.Errorf("render mismatch, got\n%s\nexpected\n%s", buffer.String(), test.expect)
+			}
+		})
+	}
+}
+
+func TestHighlightingCodeSpansDisabled(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			Highlighting,
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("call `x := 1`{:go}"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buffer.String()) != `<p>call <code>x := 1</code>{:go}</p>` {
+		t.Errorf("render mismatch, got\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,74 @@
+package highlighting
+
+import (
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// CopyButtonRenderer renders a button that copies code of a code block.
+type CopyButtonRenderer func(w util.BufWriter, context CodeBlockContext)
+
+// DefaultCopyButtonRenderer renders a button element that has a
+// "copy-button" class. Code to be copied is in a data-code attribute, so
+// scripts can copy it like
+// navigator.clipboard.writeText(button.dataset.code).
+func DefaultCopyButtonRenderer(w util.BufWriter, context CodeBlockContext) {
+	_, _ = w.WriteString(`<button type="button" class="copy-button" aria-label="Copy code" data-code="`)
+	_, _ = w.Write(util.EscapeHTML(context.Code()))
+	_, _ = w.WriteString("\">Copy</button>\n")
+}
+
+// renderCopyButton renders a copy button of the code block if copy buttons
+// are enabled.
+func (r *HTMLRenderer) renderCopyButton(w util.BufWriter, c CodeBlockContext) {
+	if !r.CopyButtons {
+		return
+	}
+	if r.CopyButtonRenderer != nil {
+		r.CopyButtonRenderer(w, c)
+	} else {
+		DefaultCopyButtonRenderer(w, c)
+	}
+}
+
+const optCopyButtons renderer.OptionName = "HighlightingCopyButtons"
+
+type withCopyButtons struct {
+	value bool
+}
+
+func (o *withCopyButtons) SetConfig(c *renderer.Config) {
+	c.Options[optCopyButtons] = o.value
+}
+
+func (o *withCopyButtons) SetHighlightingOption(c *Config) {
+	c.CopyButtons = o.value
+}
+
+// WithCopyButtons is a functional option that toggles buttons that copy
+// code of code blocks. Copied code does not contain line numbers, callout
+// markers, highlight markers, removed lines of diffs, prompts and output
+// lines of shell sessions.
+func WithCopyButtons(b bool) Option {
+	return &withCopyButtons{b}
+}
+
+const optCopyButtonRenderer renderer.OptionName = "HighlightingCopyButtonRenderer"
+
+type withCopyButtonRenderer struct {
+	value CopyButtonRenderer
+}
+
+func (o *withCopyButtonRenderer) SetConfig(c *renderer.Config) {
+	c.Options[optCopyButtonRenderer] = o.value
+}
+
+func (o *withCopyButtonRenderer) SetHighlightingOption(c *Config) {
+	c.CopyButtonRenderer = o.value
+}
+
+// WithCopyButtonRenderer is a functional option that sets a
+// CopyButtonRenderer that renders copy buttons of code blocks.
+func WithCopyButtonRenderer(b CopyButtonRenderer) Option {
+	return &withCopyButtonRenderer{b}
+}
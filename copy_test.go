@@ -0,0 +1,94 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/util"
+)
+
+func TestHighlightingCopyButtons(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithCopyButtons(true),
+				WithCallouts(true),
+				WithHighlightMarkers(true),
+			),
+		),
+	)
+	for i, c := range []struct {
+		source   string
+		expect   []string
+		unexpect []string
+	}{
+		{
+			source: "```go {linenos=true}\nif a < b {\n\treturn \"a\"\n}\n```\n",
+			expect: []string{
+				"<button type=\"button\" class=\"copy-button\" aria-label=\"Copy code\" data-code=\"if a &lt; b {\n\treturn &quot;a&quot;\n}\n\">Copy</button>\n<pre",
+			},
+		},
+		{
+			source: "```diff-go\n-x := 1 // <1>\n+x := 2 // <1>\n y := x\n```\n",
+			expect: []string{
+				"data-code=\"x := 2\ny := x\n\"",
+			},
+			unexpect: []string{"x := 1\n", "&lt;1&gt;"},
+		},
+		{
+			source: "```go\n// highlight-next-line\nx := 1\n```\n",
+			expect: []string{
+				"data-code=\"x := 1\n\"",
+			},
+		},
+		{
+			source: "```unknown\n$ ls\n```\n",
+			expect: []string{
+				"data-code=\"$ ls\n\">Copy</button>\n<pre><code class=\"language-unknown\">$ ls\n</code></pre>",
+			},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(c.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			output := buffer.String()
+			for _, e := range c.expect {
+				if !strings.Contains(output, e) {
+					t.Errorf("%q should be written, got\n%s", e, output)
+				}
+			}
+			for _, u := range c.unexpect {
+				if strings.Contains(output, u) {
+					t.Errorf("%q should not be written, got\n%s", u, output)
+				}
+			}
+		})
+	}
+}
+
+func TestHighlightingCopyButtonRenderer(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithCopyButtons(true),
+				WithCopyButtonRenderer(func(w util.BufWriter, c CodeBlockContext) {
+					_, _ = w.WriteString(`<textarea hidden>`)
+					_, _ = w.Write(util.EscapeHTML(c.Code()))
+					_, _ = w.WriteString("</textarea>\n")
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```go\nx := 1\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buffer.String(), "<textarea hidden>x := 1\n</textarea>\n<pre") {
+		t.Errorf("a custom copy button should be written, got\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,248 @@
+package highlighting
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// DarkMode specifies how CSS data of the dark style is applied.
+type DarkMode int
+
+const (
+	// DarkModeMediaQuery applies the dark style when
+	// `prefers-color-scheme: dark` media feature matches.
+	DarkModeMediaQuery DarkMode = 1 << iota
+
+	// DarkModeSelector applies the dark style when an ancestor element
+	// matches Config.DarkModeSelector.
+	DarkModeSelector
+)
+
+// DefaultDarkModeSelector is a default CSS selector that enables the dark style.
+const DefaultDarkModeSelector = "[data-theme=dark]"
+
+func (c *Config) darkStyle() *chroma.Style {
+	if c.DarkCustomStyle != nil {
+		return c.DarkCustomStyle
+	}
+	if len(c.DarkStyle) != 0 {
+		return c.getStyle(c.DarkStyle)
+	}
+	return nil
+}
+
+// darkCSS returns the given CSS data of the dark style scoped by DarkMode.
+// Each rule is written on its own line.
+func (c *Config) darkCSS(css string) string {
+	mode := c.DarkMode
+	if mode == 0 {
+		mode = DarkModeMediaQuery
+	}
+	selector := c.DarkModeSelector
+	if len(selector) == 0 {
+		selector = DefaultDarkModeSelector
+	}
+	css = strings.Replace(css, "}/* ", "}\n/* ", -1)
+	var b strings.Builder
+	for _, rule := range strings.Split(css, "\n") {
+		rule = strings.TrimSpace(rule)
+		if len(rule) == 0 {
+			continue
+		}
+		if mode&DarkModeMediaQuery != 0 {
+			b.WriteString("@media (prefers-color-scheme: dark) { ")
+			b.WriteString(rule)
+			b.WriteString(" }\n")
+		}
+		if mode&DarkModeSelector != 0 {
+			if i := strings.Index(rule, "*/ "); i > -1 {
+				b.WriteString(rule[:i+3])
+				b.WriteString(selector)
+				b.WriteByte(' ')
+				b.WriteString(rule[i+3:])
+			} else {
+				b.WriteString(selector)
+				b.WriteByte(' ')
+				b.WriteString(rule)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// cssRule is a rule of CSS data written by chroma formatters.
+type cssRule struct {
+	// selector is a selector of the rule with a leading comment.
+	selector string
+
+	// properties holds names of properties of the rule.
+	properties []string
+
+	// declarations holds declarations of the rule.
+	declarations []string
+}
+
+// parseCSS parses CSS data written by chroma formatters. Each rule is
+// expected to be written on its own line.
+func parseCSS(css string) []cssRule {
+	css = strings.Replace(css, "}/* ", "}\n/* ", -1)
+	var rules []cssRule
+	for _, line := range strings.Split(css, "\n") {
+		start := strings.LastIndex(line, "{")
+		end := strings.LastIndex(line, "}")
+		if start < 0 || end < start {
+			continue
+		}
+		rule := cssRule{selector: strings.TrimSpace(line[:start])}
+		for _, declaration := range strings.Split(line[start+1:end], ";") {
+			declaration = strings.TrimSpace(declaration)
+			i := strings.Index(declaration, ":")
+			if i < 0 {
+				continue
+			}
+			rule.properties = append(rule.properties, strings.TrimSpace(declaration[:i]))
+			rule.declarations = append(rule.declarations, declaration)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// overrideCSS returns the given CSS data of the dark style that also unsets
+// properties the light style sets but the dark style does not, so rules of
+// the light style do not leak into the dark mode.
+func overrideCSS(light, dark string) string {
+	lightRules := parseCSS(light)
+	darkRules := parseCSS(dark)
+	lightIndex := map[string]cssRule{}
+	for _, rule := range lightRules {
+		lightIndex[rule.selector] = rule
+	}
+	var b strings.Builder
+	write := func(selector string, declarations []string) {
+		if len(declarations) == 0 {
+			return
+		}
+		b.WriteString(selector)
+		b.WriteString(" { ")
+		b.WriteString(strings.Join(declarations, "; "))
+		b.WriteString(" }\n")
+	}
+	seen := map[string]bool{}
+	for _, rule := range darkRules {
+		seen[rule.selector] = true
+		declarations := rule.declarations
+		if lightRule, ok := lightIndex[rule.selector]; ok {
+			declarations = append(declarations, unsetDeclarations(lightRule.properties, rule.properties)...)
+		}
+		write(rule.selector, declarations)
+	}
+	for _, rule := range lightRules {
+		if !seen[rule.selector] {
+			write(rule.selector, unsetDeclarations(rule.properties, nil))
+		}
+	}
+	return b.String()
+}
+
+// unsetDeclarations returns declarations that unset the given properties
+// except excluded properties.
+func unsetDeclarations(properties, excluded []string) []string {
+	var declarations []string
+	for _, property := range properties {
+		found := false
+		for _, e := range excluded {
+			if e == property {
+				found = true
+				break
+			}
+		}
+		if !found {
+			declarations = append(declarations, property+": unset")
+		}
+	}
+	return declarations
+}
+
+const optDarkStyle renderer.OptionName = "HighlightingDarkStyle"
+
+type withDarkStyle struct {
+	value string
+}
+
+func (o *withDarkStyle) SetConfig(c *renderer.Config) {
+	c.Options[optDarkStyle] = o.value
+}
+
+func (o *withDarkStyle) SetHighlightingOption(c *Config) {
+	c.DarkStyle = o.value
+}
+
+// WithDarkStyle is a functional option that sets a highlighting style used
+// in the dark mode.
+func WithDarkStyle(style string) Option {
+	return &withDarkStyle{style}
+}
+
+const optDarkCustomStyle renderer.OptionName = "HighlightingDarkCustomStyle"
+
+type withDarkCustomStyle struct {
+	value *chroma.Style
+}
+
+func (o *withDarkCustomStyle) SetConfig(c *renderer.Config) {
+	c.Options[optDarkCustomStyle] = o.value
+}
+
+func (o *withDarkCustomStyle) SetHighlightingOption(c *Config) {
+	c.DarkCustomStyle = o.value
+}
+
+// WithDarkCustomStyle is a functional option that sets a custom Chroma style
+// used in the dark mode.
+func WithDarkCustomStyle(style *chroma.Style) Option {
+	return &withDarkCustomStyle{style}
+}
+
+const optDarkMode renderer.OptionName = "HighlightingDarkMode"
+
+type withDarkMode struct {
+	value DarkMode
+}
+
+func (o *withDarkMode) SetConfig(c *renderer.Config) {
+	c.Options[optDarkMode] = o.value
+}
+
+func (o *withDarkMode) SetHighlightingOption(c *Config) {
+	c.DarkMode = o.value
+}
+
+// WithDarkMode is a functional option that sets how CSS data of the dark
+// style is applied.
+func WithDarkMode(mode DarkMode) Option {
+	return &withDarkMode{mode}
+}
+
+const optDarkModeSelector renderer.OptionName = "HighlightingDarkModeSelector"
+
+type withDarkModeSelector struct {
+	value string
+}
+
+func (o *withDarkModeSelector) SetConfig(c *renderer.Config) {
+	c.Options[optDarkModeSelector] = o.value
+}
+
+func (o *withDarkModeSelector) SetHighlightingOption(c *Config) {
+	c.DarkModeSelector = o.value
+}
+
+// WithDarkModeSelector is a functional option that sets a CSS selector that
+// enables the dark style.
+func WithDarkModeSelector(selector string) Option {
+	return &withDarkModeSelector{selector}
+}
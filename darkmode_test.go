@@ -0,0 +1,75 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingDarkStyle(t *testing.T) {
+	for i, test := range []struct {
+		options  []Option
+		expect   []string
+		unexpect []string
+	}{
+		{
+			nil,
+			[]string{
+				"/* Keyword */ .chroma .k { color: #000000; font-weight: bold }\n",
+				"@media (prefers-color-scheme: dark) { /* Keyword */ .chroma .k { color: #66d9ef; font-weight: unset } }\n",
+				"@media (prefers-color-scheme: dark) { /* GenericHeading */ .chroma .gh { color: unset } }\n",
+			},
+			[]string{"[data-theme=dark]"},
+		},
+		{
+			[]Option{WithDarkMode(DarkModeSelector)},
+			[]string{
+				"/* Keyword */ .chroma .k { color: #000000; font-weight: bold }\n",
+				"/* Keyword */ [data-theme=dark] .chroma .k { color: #66d9ef; font-weight: unset }\n",
+			},
+			[]string{"@media"},
+		},
+		{
+			[]Option{WithDarkMode(DarkModeMediaQuery | DarkModeSelector), WithDarkModeSelector(".dark")},
+			[]string{
+				"@media (prefers-color-scheme: dark) { /* Keyword */ .chroma .k { color: #66d9ef; font-weight: unset } }\n",
+				"/* Keyword */ .dark .chroma .k { color: #66d9ef; font-weight: unset }\n",
+			},
+			nil,
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var css bytes.Buffer
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(append([]Option{
+						WithStyle("github"),
+						WithDarkStyle("monokai"),
+						WithCSSWriter(&css),
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					}, test.options...)...),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte("```go\nx := 1\n```\n"), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expect {
+				if !strings.Contains(css.String(), e) {
+					t.Errorf("%q should be written, got\n%s", e, css.String())
+				}
+			}
+			for _, e := range test.unexpect {
+				if strings.Contains(css.String(), e) {
+					t.Errorf("%q should not be written, got\n%s", e, css.String())
+				}
+			}
+		})
+	}
+}
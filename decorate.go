@@ -0,0 +1,229 @@
+package highlighting
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+// placeholderType is a token type of placeholders. chroma formatters write
+// tokens of unknown types without any styles.
+const placeholderType chroma.TokenType = -1000
+
+// placeholders are enclosed in characters of the private use area.
+const placeholderStart = "\uE000"
+const placeholderEnd = "\uE001"
+
+// decoration is markup that is put into highlighted code.
+type decoration struct {
+	// markup is written in place of the placeholder.
+	markup string
+
+	// lineClass is added to classes of the line that contains the
+	// placeholder if the formatter uses classes.
+	lineClass string
+
+	// lineStyle is added to the inline style of the line that contains
+	// the placeholder if the formatter does not use classes.
+	lineStyle string
+}
+
+// decorator modifies tokens of a code block split into lines.
+type decorator func(d *decorations, lines [][]chroma.Token) [][]chroma.Token
+
+// decorations decorates highlighted code of a code block.
+//
+// chroma formatters escape token values, so decorations are put into token
+// streams as placeholders before formatting and replaced with markup after
+// formatting.
+type decorations struct {
+	// key identifies the decorations in cache keys.
+	key string
+
+	decorators []decorator
+
+	// css holds functions that return CSS data the decorations need for
+	// the given class prefix and style.
+	css []func(prefix string, style *chroma.Style) string
+
+	items []decoration
+}
+
+// add adds the given decorator. key must identify what the decorator does.
+func (d *decorations) add(key string, f decorator) {
+	d.key += key + "\x00"
+	d.decorators = append(d.decorators, f)
+}
+
+// addCSS adds a function that returns CSS data the decorations need.
+func (d *decorations) addCSS(f func(prefix string, style *chroma.Style) string) {
+	d.css = append(d.css, f)
+}
+
+// cssFor returns CSS data the decorations need for the given class
+// prefix and style.
+func (d *decorations) cssFor(prefix string, style *chroma.Style) string {
+	var b strings.Builder
+	for _, f := range d.css {
+		b.WriteString(f(prefix, style))
+	}
+	return b.String()
+}
+
+// placeholder returns a token that is replaced with the given decoration.
+func (d *decorations) placeholder(item decoration) chroma.Token {
+	d.items = append(d.items, item)
+	return chroma.Token{
+		Type:  placeholderType,
+		Value: placeholderStart + strconv.Itoa(len(d.items)-1) + placeholderEnd,
+	}
+}
+
+// escape replaces characters of placeholders in the given code with
+// placeholders of the characters, so they are not taken for placeholders.
+func (d *decorations) escape(code string) string {
+	if !strings.ContainsAny(code, placeholderStart+placeholderEnd) {
+		return code
+	}
+	var b strings.Builder
+	for _, c := range code {
+		if s := string(c); s == placeholderStart || s == placeholderEnd {
+			b.WriteString(d.placeholder(decoration{markup: s}).Value)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// decorate applies decorators to the given tokens.
+func (d *decorations) decorate(tokens []chroma.Token) []chroma.Token {
+	d.items = d.items[:0]
+	for i := range tokens {
+		tokens[i].Value = d.escape(tokens[i].Value)
+	}
+	lines := chroma.SplitTokensIntoLines(tokens)
+	for _, f := range d.decorators {
+		lines = f(d, lines)
+	}
+	var result []chroma.Token
+	for _, line := range lines {
+		result = append(result, line...)
+	}
+	return result
+}
+
+// replace replaces placeholders in the given highlighted code with
+// decorations.
+func (d *decorations) replace(highlighted []byte) []byte {
+	var out []byte
+	for {
+		start := bytes.Index(highlighted, []byte(placeholderStart))
+		if start < 0 {
+			break
+		}
+		end := bytes.Index(highlighted[start:], []byte(placeholderEnd))
+		if end < 0 {
+			break
+		}
+		end += start
+		index, err := strconv.Atoi(string(highlighted[start+len(placeholderStart) : end]))
+		out = append(out, highlighted[:start]...)
+		highlighted = highlighted[end+len(placeholderEnd):]
+		if err != nil || index < 0 || index >= len(d.items) {
+			continue
+		}
+		item := d.items[index]
+		if len(item.lineClass) != 0 || len(item.lineStyle) != 0 {
+			out = decorateLine(out, item)
+		}
+		out = append(out, item.markup...)
+	}
+	return append(out, highlighted...)
+}
+
+// appendToLine appends the given tokens to the given line before its
+// trailing newline.
+func appendToLine(line []chroma.Token, tokens ...chroma.Token) []chroma.Token {
+	var newline []chroma.Token
+	if len(line) != 0 {
+		last := line[len(line)-1]
+		if strings.HasSuffix(last.Value, "\n") {
+			line = line[:len(line)-1]
+			if len(last.Value) > 1 {
+				line = append(line, chroma.Token{Type: last.Type, Value: last.Value[:len(last.Value)-1]})
+			}
+			newline = []chroma.Token{{Type: last.Type, Value: "\n"}}
+		}
+	}
+	line = append(line, tokens...)
+	return append(line, newline...)
+}
+
+var spanStart = []byte("<span")
+var spanEnd = []byte("</span>")
+var classAttribute = []byte(` class="`)
+var styleAttribute = []byte(` style="`)
+
+// decorateLine adds a class or an inline style of the given decoration to
+// the last opened line element in the given highlighted code.
+//
+// chroma writes a line as <span LINE><span LINE_NUMBER>..</span><span CODE_LINE>..,
+// so the line element is the second unclosed span element from the end.
+func decorateLine(highlighted []byte, item decoration) []byte {
+	unclosed := 0
+	pos := -1
+	for i := len(highlighted) - 1; i >= 0; i-- {
+		if bytes.HasPrefix(highlighted[i:], spanEnd) {
+			unclosed--
+		} else if bytes.HasPrefix(highlighted[i:], spanStart) {
+			unclosed++
+			if unclosed == 2 {
+				pos = i
+				break
+			}
+		}
+	}
+	if pos < 0 {
+		return highlighted
+	}
+	tagEnd := bytes.IndexByte(highlighted[pos:], '>')
+	if tagEnd < 0 {
+		return highlighted
+	}
+	tag := highlighted[pos : pos+tagEnd]
+	var attr []byte
+	var value string
+	start := bytes.Index(tag, classAttribute)
+	if start > -1 && len(item.lineClass) != 0 {
+		// classes of chroma are prefixed like PREFIXline.
+		classes := tag[start+len(classAttribute):]
+		first := classes
+		if i := bytes.IndexAny(classes, ` "`); i > -1 {
+			first = classes[:i]
+		}
+		prefix := bytes.TrimSuffix(first, []byte("line"))
+		attr = classAttribute
+		value = " " + string(prefix) + item.lineClass
+	} else if start = bytes.Index(tag, styleAttribute); start > -1 && len(item.lineStyle) != 0 {
+		attr = styleAttribute
+		value = item.lineStyle
+	} else {
+		return highlighted
+	}
+	start += len(attr)
+	valueEnd := bytes.IndexByte(tag[start:], '"')
+	if valueEnd < 0 {
+		return highlighted
+	}
+	insert := pos + start + valueEnd
+	if bytes.Equal(attr, styleAttribute) && valueEnd > 0 && highlighted[insert-1] != ';' {
+		value = ";" + value
+	}
+	result := make([]byte, 0, len(highlighted)+len(value))
+	result = append(result, highlighted[:insert]...)
+	result = append(result, value...)
+	return append(result, highlighted[insert:]...)
+}
@@ -0,0 +1,151 @@
+package highlighting
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// UnknownLanguage holds information about code whose language is not
+// supported by any lexers.
+type UnknownLanguage struct {
+	// Language is a language of the code.
+	Language []byte
+
+	// Line is a 1-based line number of the code in the source.
+	// Line is 0 if the position is unknown.
+	Line int
+
+	// Suggestions are known language names that are similar to the Language.
+	Suggestions []string
+}
+
+// UnknownLanguageHandler receives code whose language is not supported by
+// any lexers.
+type UnknownLanguageHandler func(u *UnknownLanguage)
+
+const maxSuggestions = 5
+
+// languageNames returns all known language names.
+func (c *Config) languageNames() []string {
+	names := lexers.Names(true)
+	if c.Lexers != nil {
+		names = append(names, c.Lexers.Names(true)...)
+	}
+	for name := range c.LanguageAliases {
+		names = append(names, name)
+	}
+	for name := range c.LanguageAliasLexers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// reportUnknownLanguage passes the given language to the UnknownLanguageHandler.
+func (c *Config) reportUnknownLanguage(language []byte, line int) {
+	if c.UnknownLanguageHandler == nil {
+		return
+	}
+	c.UnknownLanguageHandler(&UnknownLanguage{
+		Language:    language,
+		Line:        line,
+		Suggestions: suggestLanguages(string(language), c.languageNames()),
+	})
+}
+
+// suggestLanguages returns names that are similar to the given language.
+func suggestLanguages(language string, names []string) []string {
+	language = strings.ToLower(language)
+	maxDistance := 1 + len(language)/4
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if d := editDistance(language, name); d <= maxDistance {
+			candidates = append(candidates, candidate{name, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if len(candidates) > maxSuggestions {
+		candidates = candidates[:maxSuggestions]
+	}
+	suggestions := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		suggestions = append(suggestions, c.name)
+	}
+	return suggestions
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// that is Levenshtein distance that also counts a transposition of two
+// adjacent characters as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+const optUnknownLanguageHandler renderer.OptionName = "HighlightingUnknownLanguageHandler"
+
+type withUnknownLanguageHandler struct {
+	value UnknownLanguageHandler
+}
+
+func (o *withUnknownLanguageHandler) SetConfig(c *renderer.Config) {
+	c.Options[optUnknownLanguageHandler] = o.value
+}
+
+func (o *withUnknownLanguageHandler) SetHighlightingOption(c *Config) {
+	c.UnknownLanguageHandler = o.value
+}
+
+// WithUnknownLanguageHandler is a functional option that sets an
+// UnknownLanguageHandler that receives code whose language is not supported
+// by any lexers.
+func WithUnknownLanguageHandler(h UnknownLanguageHandler) Option {
+	return &withUnknownLanguageHandler{h}
+}
@@ -0,0 +1,61 @@
+package highlighting
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingUnknownLanguage(t *testing.T) {
+	var reported []*UnknownLanguage
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithUnknownLanguageHandler(func(u *UnknownLanguage) {
+					reported = append(reported, u)
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```go\nx := 1\n```\n\n```goalng\nx := 1\n```\n\n```yml2\na: 1\n```\n\n```unknown {filename=\"main.py\"}\nx = 1\n```\n\n```goalng {nohl=true}\nx := 1\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("2 unknown languages should be reported, got %d", len(reported))
+	}
+	if string(reported[0].Language) != "goalng" || reported[0].Line != 5 || reported[0].Suggestions[0] != "golang" {
+		t.Errorf("unexpected report: %+v", reported[0])
+	}
+	if string(reported[1].Language) != "yml2" || reported[1].Line != 9 || !containsString(reported[1].Suggestions, "yaml") {
+		t.Errorf("unexpected report: %+v", reported[1])
+	}
+}
+
+func TestSuggestLanguages(t *testing.T) {
+	names := []string{"Go", "golang", "Python", "python3", "YAML", "yml"}
+	for _, test := range []struct {
+		language string
+		expect   []string
+	}{
+		{"goalng", []string{"golang"}},
+		{"pyton", []string{"python", "python3"}},
+		{"YML2", []string{"yml", "yaml"}},
+		{"haskell", []string{}},
+	} {
+		if s := suggestLanguages(test.language, names); !reflect.DeepEqual(s, test.expect) {
+			t.Errorf("%s: expected %v, got %v", test.language, test.expect, s)
+		}
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
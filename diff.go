@@ -0,0 +1,148 @@
+package highlighting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+var diffAttrName = []byte("diff")
+
+// diffLanguagePrefix is a prefix of languages like diff-go that highlight
+// diffs with lexers of the underlying languages.
+const diffLanguagePrefix = "diff-"
+
+// DiffAddedClass is a CSS class of added lines in diffs.
+const DiffAddedClass = "diff-add"
+
+// DiffRemovedClass is a CSS class of removed lines in diffs.
+const DiffRemovedClass = "diff-remove"
+
+// diffLanguage returns the underlying language if the given language is
+// a form of diff-LANGUAGE.
+func (c *Config) diffLanguage(language []byte) ([]byte, bool) {
+	lang := string(language)
+	if !strings.HasPrefix(strings.ToLower(lang), diffLanguagePrefix) || c.getLexer(language) != nil {
+		return nil, false
+	}
+	lang = lang[len(diffLanguagePrefix):]
+	if len(lang) == 0 || c.getLexer([]byte(lang)) == nil {
+		return nil, false
+	}
+	return []byte(lang), true
+}
+
+// isDiff returns true if the given attributes enable the diff mode.
+func isDiff(attrs ImmutableAttributes) bool {
+	if attrs == nil {
+		return false
+	}
+	v, ok := attrs.Get(diffAttrName)
+	if !ok {
+		return false
+	}
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return true
+}
+
+// stripDiff strips +/- markers from lines of the given code. This returns
+// the stripped code and markers of lines.
+func stripDiff(code string) (string, string) {
+	var b strings.Builder
+	var markers strings.Builder
+	for _, line := range strings.SplitAfter(code, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case '+', '-':
+			markers.WriteByte(line[0])
+			line = line[1:]
+		case ' ':
+			markers.WriteByte(' ')
+			line = line[1:]
+		default:
+			markers.WriteByte(' ')
+		}
+		b.WriteString(line)
+	}
+	return b.String(), markers.String()
+}
+
+// stripRemovedLines strips lines marked as removed from the given code.
+// markers are returned by stripDiff.
+func stripRemovedLines(code string, markers string) string {
+	var b strings.Builder
+	for i, line := range strings.SplitAfter(code, "\n") {
+		if i < len(markers) && markers[i] == '-' {
+			continue
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// addDiffDecorations adds decorations that mark added and removed lines.
+// markers are returned by stripDiff.
+func addDiffDecorations(d *decorations, markers string, style *chroma.Style) {
+	added := decoration{
+		lineClass: DiffAddedClass,
+		lineStyle: "background-color:" + diffBackground(style, chroma.GenericInserted).String() + ";",
+	}
+	removed := decoration{
+		lineClass: DiffRemovedClass,
+		lineStyle: "background-color:" + diffBackground(style, chroma.GenericDeleted).String() + ";",
+	}
+	d.add("diff:"+markers, func(d *decorations, lines [][]chroma.Token) [][]chroma.Token {
+		for i, line := range lines {
+			if i >= len(markers) {
+				break
+			}
+			switch markers[i] {
+			case '+':
+				lines[i] = append([]chroma.Token{d.placeholder(added)}, line...)
+			case '-':
+				lines[i] = append([]chroma.Token{d.placeholder(removed)}, line...)
+			}
+		}
+		return lines
+	})
+	d.addCSS(diffCSS)
+}
+
+// diffCSS returns CSS data for lines in diffs.
+func diffCSS(prefix string, style *chroma.Style) string {
+	return fmt.Sprintf("/* DiffAdded */ .%[1]schroma .%[1]s%[2]s { background-color: %[3]s }\n"+
+		"/* DiffRemoved */ .%[1]schroma .%[1]s%[4]s { background-color: %[5]s }\n",
+		prefix, DiffAddedClass, diffBackground(style, chroma.GenericInserted),
+		DiffRemovedClass, diffBackground(style, chroma.GenericDeleted))
+}
+
+// diffBackground returns a background color of lines of the given token
+// type. If the style does not define a background color of the type,
+// this blends the foreground color of the type into the background.
+func diffBackground(style *chroma.Style, tt chroma.TokenType) chroma.Colour {
+	bg := style.Get(chroma.Background).Background
+	entry := style.Get(tt)
+	if entry.Background.IsSet() && entry.Background != bg {
+		return entry.Background
+	}
+	fg := entry.Colour
+	if !fg.IsSet() || fg == style.Get(chroma.Text).Colour {
+		if tt == chroma.GenericInserted {
+			fg = chroma.MustParseColour("#00a000")
+		} else {
+			fg = chroma.MustParseColour("#a00000")
+		}
+	}
+	if !bg.IsSet() {
+		bg = chroma.MustParseColour("#ffffff")
+	}
+	blend := func(a, b uint8) uint8 {
+		return uint8((int(a)*3 + int(b)*7) / 10)
+	}
+	return chroma.NewColour(blend(fg.Red(), bg.Red()), blend(fg.Green(), bg.Green()), blend(fg.Blue(), bg.Blue()))
+}
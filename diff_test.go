@@ -0,0 +1,102 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingDiff(t *testing.T) {
+	for i, test := range []struct {
+		source   string
+		classes  bool
+		expect   []string
+		unexpect []string
+	}{
+		{
+			"```diff-go\n x := 1\n-y := 2\n+y := 3\n```\n",
+			true,
+			[]string{
+				`<span class="line"><span class="cl"><span class="nx">x</span>`,
+				`<span class="line diff-remove"><span class="cl"><span class="nx">y</span>`,
+				`<span class="line diff-add"><span class="cl"><span class="nx">y</span>`,
+				"/* DiffAdded */ .chroma .diff-add { background-color: #ddffdd }\n",
+				"/* DiffRemoved */ .chroma .diff-remove { background-color: #ffdddd }\n",
+			},
+			[]string{"+", "-y", "gi", "gd"},
+		},
+		{
+			"```go {diff=true}\n x := 1\n+y := 3\n```\n",
+			false,
+			[]string{
+				`<span style="display:flex;background-color:#ddffdd;"><span>y`,
+			},
+			[]string{"+", "background-color:#ffdddd"},
+		},
+		{
+			"```go {diff=false}\n+y := 3\n```\n",
+			false,
+			[]string{"+"},
+			[]string{"background-color:#ddffdd"},
+		},
+		{
+			"```diff\n+y := 3\n```\n",
+			true,
+			[]string{`<span class="line"><span class="cl"><span class="gi">+y := 3`},
+			[]string{"diff-add"},
+		},
+		{
+			"```go {diff=true}\n+s := \"\uE000 abc \uE001\"\n+t := \"\uE0001\uE001\"\n```\n",
+			true,
+			[]string{
+				"<span class=\"s\">&#34;\uE000 abc \uE001&#34;</span>",
+				"<span class=\"s\">&#34;\uE0001\uE001&#34;</span>",
+			},
+			[]string{"+"},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var css bytes.Buffer
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(
+						WithStyle("github"),
+						WithCSSWriter(&css),
+						WithFormatOptions(
+							chromahtml.WithClasses(test.classes),
+						),
+					),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			output := buffer.String() + css.String()
+			for _, e := range test.expect {
+				if !strings.Contains(output, e) {
+					t.Errorf("%q should be written, got\n%s", e, output)
+				}
+			}
+			for _, e := range test.unexpect {
+				if strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should not be written, got\n%s", e, buffer.String())
+				}
+			}
+		})
+	}
+}
+
+func TestDiffBackground(t *testing.T) {
+	style := styles.Get("monokai")
+	// monokai defines only foreground colors of inserted lines.
+	if c := diffBackground(style, chroma.GenericInserted); c == style.Get(chroma.Background).Background {
+		t.Errorf("background of inserted lines should differ from the background, got %s", c)
+	}
+}
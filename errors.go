@@ -0,0 +1,190 @@
+package highlighting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// ErrorPolicy specifies how errors occurred while highlighting are handled.
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyIgnore ignores errors. Code that can not be tokenized is
+	// rendered as plain text and partially formatted output is kept.
+	ErrorPolicyIgnore ErrorPolicy = iota
+
+	// ErrorPolicyFallback renders code as plain text when an error occurs.
+	ErrorPolicyFallback
+
+	// ErrorPolicyFail aborts the conversion with the error.
+	ErrorPolicyFail
+
+	// ErrorPolicyReport passes errors to Config.ErrorHandler and renders
+	// code as plain text.
+	ErrorPolicyReport
+)
+
+const (
+	opTokenise  = "tokenise"
+	opFormat    = "format"
+	opWriteCSS  = "write css"
+	opInclude   = "include"
+	opConfigure = "configure"
+	opWrap      = "wrap"
+)
+
+// ErrRenderingStopped is an underlying error of a HighlightError returned
+// when WrapperRendererE stops rendering with ast.WalkStop and no error.
+var ErrRenderingStopped = errors.New("rendering stopped")
+
+// HighlightError is an error occurred while highlighting code.
+type HighlightError struct {
+	// Op is an operation that failed. One of "tokenise", "format",
+	// "write css", "include", "configure" and "wrap".
+	Op string
+
+	// Language is a language of the code.
+	Language []byte
+
+	// Line is a 1-based line number of the code in the source.
+	// Line is 0 if the position is unknown.
+	Line int
+
+	// Err is an underlying error.
+	Err error
+}
+
+func newHighlightError(op string, language []byte, line int, err error) *HighlightError {
+	return &HighlightError{
+		Op:       op,
+		Language: language,
+		Line:     line,
+		Err:      err,
+	}
+}
+
+// Error implements error.Error. Messages look like
+// "highlighting: line 3 (go): write css: <error>". An unknown line and an
+// empty language are omitted.
+func (e *HighlightError) Error() string {
+	var b strings.Builder
+	b.WriteString("highlighting: ")
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d", e.Line)
+	}
+	if len(e.Language) != 0 {
+		if e.Line > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "(%s)", e.Language)
+	}
+	if e.Line > 0 || len(e.Language) != 0 {
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s: %s", e.Op, e.Err)
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *HighlightError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler receives errors occurred while highlighting code.
+type ErrorHandler func(err *HighlightError)
+
+// handleError handles the given error according to the ErrorPolicy and
+// returns an error that should abort the conversion.
+func (c *Config) handleError(err *HighlightError) error {
+	switch c.ErrorPolicy {
+	case ErrorPolicyFail:
+		return err
+	case ErrorPolicyReport:
+		if c.ErrorHandler != nil {
+			c.ErrorHandler(err)
+		}
+	}
+	return nil
+}
+
+// handleIncludeError handles the given error of an included file. Unlike
+// other errors, this is passed to ErrorHandler with any ErrorPolicy, since
+// authors expect included code to be rendered.
+func (c *Config) handleIncludeError(err *HighlightError) error {
+	if c.ErrorPolicy == ErrorPolicyFail {
+		return err
+	}
+	if c.ErrorHandler != nil {
+		c.ErrorHandler(err)
+	}
+	return nil
+}
+
+// lineAt returns a 1-based line number of the given offset in the source.
+func lineAt(source []byte, offset int) int {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	return bytes.Count(source[:offset], []byte{'\n'}) + 1
+}
+
+// codeBlockLine returns a 1-based line number of the given code block.
+// For fenced code blocks, this is a line number of the opening fence.
+func codeBlockLine(source []byte, n ast.Node) int {
+	if fcb, ok := n.(*ast.FencedCodeBlock); ok && fcb.Info != nil {
+		return lineAt(source, fcb.Info.Segment.Start)
+	}
+	if n.Lines().Len() == 0 {
+		return 0
+	}
+	line := lineAt(source, n.Lines().At(0).Start)
+	if n.Kind() == ast.KindFencedCodeBlock {
+		line--
+	}
+	return line
+}
+
+const optErrorPolicy renderer.OptionName = "HighlightingErrorPolicy"
+
+type withErrorPolicy struct {
+	value ErrorPolicy
+}
+
+func (o *withErrorPolicy) SetConfig(c *renderer.Config) {
+	c.Options[optErrorPolicy] = o.value
+}
+
+func (o *withErrorPolicy) SetHighlightingOption(c *Config) {
+	c.ErrorPolicy = o.value
+}
+
+// WithErrorPolicy is a functional option that sets how errors occurred while
+// highlighting are handled.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return &withErrorPolicy{p}
+}
+
+const optErrorHandler renderer.OptionName = "HighlightingErrorHandler"
+
+type withErrorHandler struct {
+	value ErrorHandler
+}
+
+func (o *withErrorHandler) SetConfig(c *renderer.Config) {
+	c.Options[optErrorHandler] = o.value
+}
+
+func (o *withErrorHandler) SetHighlightingOption(c *Config) {
+	c.ErrorHandler = o.value
+}
+
+// WithErrorHandler is a functional option that sets an ErrorHandler used
+// with ErrorPolicyReport.
+func WithErrorHandler(h ErrorHandler) Option {
+	return &withErrorHandler{h}
+}
@@ -0,0 +1,166 @@
+package highlighting
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+var errBroken = errors.New("broken")
+
+type brokenLexer struct{}
+
+func (l *brokenLexer) Config() *chroma.Config {
+	return &chroma.Config{Name: "broken", Aliases: []string{"broken"}}
+}
+
+func (l *brokenLexer) Tokenise(options *chroma.TokeniseOptions, text string) (chroma.Iterator, error) {
+	return nil, errBroken
+}
+
+func (l *brokenLexer) SetRegistry(registry *chroma.LexerRegistry) chroma.Lexer {
+	return l
+}
+
+func (l *brokenLexer) SetAnalyser(analyser func(text string) float32) chroma.Lexer {
+	return l
+}
+
+func (l *brokenLexer) AnalyseText(text string) float32 {
+	return 0
+}
+
+// withBrokenLexer is an option that makes the "broken" language fail to
+// tokenise without registering the lexer globally.
+var withBrokenLexer = WithLanguageAliasLexer("broken", &brokenLexer{})
+
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) {
+	return 0, errBroken
+}
+
+func TestHighlightingErrorPolicy(t *testing.T) {
+	source := []byte("Title\n\n```broken\nx := 1\n```\n")
+
+	var buffer bytes.Buffer
+	markdown := goldmark.New(goldmark.WithExtensions(NewHighlighting(withBrokenLexer)))
+	if err := markdown.Convert(source, &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `<pre><code class="language-broken">x := 1`) {
+		t.Errorf("should fall back to plain text, got\n%s", buffer.String())
+	}
+
+	buffer.Reset()
+	markdown = goldmark.New(goldmark.WithExtensions(NewHighlighting(withBrokenLexer, WithErrorPolicy(ErrorPolicyFail))))
+	err := markdown.Convert(source, &buffer)
+	var herr *HighlightError
+	if !errors.As(err, &herr) || herr.Op != "tokenise" || herr.Line != 3 || string(herr.Language) != "broken" || !errors.Is(err, errBroken) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	buffer.Reset()
+	var reported []*HighlightError
+	markdown = goldmark.New(goldmark.WithExtensions(NewHighlighting(
+		withBrokenLexer,
+		WithErrorPolicy(ErrorPolicyReport),
+		WithErrorHandler(func(err *HighlightError) {
+			reported = append(reported, err)
+		}),
+	)))
+	if err := markdown.Convert(source, &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || reported[0].Line != 3 {
+		t.Errorf("unexpected reported errors: %v", reported)
+	}
+	if !strings.Contains(buffer.String(), `<pre><code class="language-broken">x := 1`) {
+		t.Errorf("should fall back to plain text, got\n%s", buffer.String())
+	}
+}
+
+func TestHighlightingErrorPolicyCSS(t *testing.T) {
+	source := []byte("```go\nx := 1\n```\n")
+	for _, policy := range []ErrorPolicy{ErrorPolicyIgnore, ErrorPolicyFallback} {
+		var buffer bytes.Buffer
+		markdown := goldmark.New(goldmark.WithExtensions(NewHighlighting(
+			WithErrorPolicy(policy),
+			WithCSSWriter(brokenWriter{}),
+			WithFormatOptions(chromahtml.WithClasses(true)),
+		)))
+		if err := markdown.Convert(source, &buffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buffer bytes.Buffer
+	markdown := goldmark.New(goldmark.WithExtensions(NewHighlighting(
+		WithErrorPolicy(ErrorPolicyFail),
+		WithCSSWriter(brokenWriter{}),
+		WithFormatOptions(chromahtml.WithClasses(true)),
+	)))
+	err := markdown.Convert(source, &buffer)
+	var herr *HighlightError
+	if !errors.As(err, &herr) || herr.Op != "write css" || herr.Line != 1 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHookErrors(t *testing.T) {
+	errHook := errors.New("hook")
+	source := []byte("# Title\n\n```go\nx := 1\n```\n")
+	for i, c := range []struct {
+		option Option
+		op     string
+		err    error
+	}{
+		{WithCodeBlockOptionsE(func(c CodeBlockContext) ([]chromahtml.Option, error) {
+			return nil, errHook
+		}), "configure", errHook},
+		{WithWrapperRendererE(func(w util.BufWriter, c CodeBlockContext, entering bool) (ast.WalkStatus, error) {
+			if entering {
+				return ast.WalkContinue, nil
+			}
+			return ast.WalkStop, errHook
+		}), "wrap", errHook},
+		{WithWrapperRendererE(func(w util.BufWriter, c CodeBlockContext, entering bool) (ast.WalkStatus, error) {
+			return ast.WalkStop, nil
+		}), "wrap", ErrRenderingStopped},
+	} {
+		var buffer bytes.Buffer
+		markdown := goldmark.New(goldmark.WithExtensions(NewHighlighting(
+			WithErrorPolicy(ErrorPolicyIgnore),
+			c.option,
+		)))
+		err := markdown.Convert(source, &buffer)
+		var herr *HighlightError
+		if !errors.As(err, &herr) || herr.Op != c.op || herr.Line != 3 || !errors.Is(err, c.err) {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestHighlightErrorMessage(t *testing.T) {
+	errTest := errors.New("test")
+	for i, test := range []struct {
+		err    *HighlightError
+		expect string
+	}{
+		{newHighlightError(opWriteCSS, []byte("go"), 3, errTest), "highlighting: line 3 (go): write css: test"},
+		{newHighlightError(opInclude, nil, 3, errTest), "highlighting: line 3: include: test"},
+		{newHighlightError(opTokenise, []byte("go"), 0, errTest), "highlighting: (go): tokenise: test"},
+		{newHighlightError(opInclude, nil, 0, errTest), "highlighting: include: test"},
+	} {
+		if message := test.err.Error(); message != test.expect {
+			t.Errorf("%d: expected %q, got %q", i, test.expect, message)
+		}
+	}
+}
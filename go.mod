@@ -1,6 +1,6 @@
 module github.com/yuin/goldmark-highlighting/v2
 
-go 1.13
+go 1.16
 
 require (
 	github.com/alecthomas/chroma/v2 v2.2.0
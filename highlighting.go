@@ -6,9 +6,13 @@ package highlighting
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/fs"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
@@ -20,7 +24,6 @@ import (
 
 	"github.com/alecthomas/chroma/v2"
 	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
-	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/alecthomas/chroma/v2/styles"
 )
 
@@ -67,20 +70,67 @@ type CodeBlockContext interface {
 
 	// Attributes return attributes of the code block.
 	Attributes() ImmutableAttributes
+
+	// Title returns (title, true) if the code block has a title or filename
+	// attribute, otherwise (nil, false).
+	Title() ([]byte, bool)
+
+	// LanguageDetection returns how the language is detected.
+	LanguageDetection() LanguageDetection
+
+	// Code returns code of the code block without markers like callouts,
+	// highlight markers and diff markers. Lines removed in diffs, prompts
+	// and output lines of shell sessions are excluded.
+	Code() []byte
+
+	// LineCount returns the number of lines of Code().
+	LineCount() int
+
+	// Lexer returns a chroma lexer used for highlighting the code block,
+	// or nil if the code block is not highlighted.
+	Lexer() chroma.Lexer
+
+	// Style returns a chroma style used for highlighting the code block,
+	// or nil if the code block is not highlighted.
+	Style() *chroma.Style
+
+	// Line returns a 1-based line number of the code block in the source.
+	// For fenced code blocks, this is a line number of the opening fence.
+	// Line returns 0 if the position is unknown.
+	Line() int
+
+	// Node returns the code block node. This is an *ast.FencedCodeBlock or
+	// an *ast.CodeBlock.
+	Node() ast.Node
 }
 
 type codeBlockContext struct {
 	language    []byte
+	detection   LanguageDetection
 	highlighted bool
 	attributes  ImmutableAttributes
+	code        []byte
+	lexer       chroma.Lexer
+	style       *chroma.Style
+	line        int
+	node        ast.Node
 }
 
-func newCodeBlockContext(language []byte, highlighted bool, attrs ImmutableAttributes) CodeBlockContext {
-	return &codeBlockContext{
-		language:    language,
+func newCodeBlockContext(cb *codeBlock, highlighted bool) CodeBlockContext {
+	c := &codeBlockContext{
+		language:    cb.language,
+		detection:   cb.detection,
 		highlighted: highlighted,
-		attributes:  attrs,
+		attributes:  cb.attrs,
+		code:        cb.rawCode,
+		line:        cb.line,
+		node:        cb.node,
 	}
+	if highlighted {
+		c.lexer = cb.lexer
+		c.style = cb.style
+	}
+	return c
 }
 
 func (c *codeBlockContext) Language() ([]byte, bool) {
@@ -98,12 +148,86 @@ func (c *codeBlockContext) Attributes() ImmutableAttributes {
 	return c.attributes
 }
 
+func (c *codeBlockContext) LanguageDetection() LanguageDetection {
+	return c.detection
+}
+
+func (c *codeBlockContext) Code() []byte {
+	return c.code
+}
+
+func (c *codeBlockContext) LineCount() int {
+	n := bytes.Count(c.code, []byte{'\n'})
+	if len(c.code) != 0 && c.code[len(c.code)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+func (c *codeBlockContext) Lexer() chroma.Lexer {
+	return c.lexer
+}
+
+func (c *codeBlockContext) Style() *chroma.Style {
+	return c.style
+}
+
+func (c *codeBlockContext) Line() int {
+	return c.line
+}
+
+func (c *codeBlockContext) Node() ast.Node {
+	return c.node
+}
+
+func (c *codeBlockContext) Title() ([]byte, bool) {
+	return codeBlockTitle(c.attributes)
+}
+
+// codeBlockTitle returns (title, true) if the given attributes have a title
+// or filename attribute, otherwise (nil, false).
+func codeBlockTitle(attrs ImmutableAttributes) ([]byte, bool) {
+	if attrs == nil {
+		return nil, false
+	}
+	for _, name := range [][]byte{titleAttrName, filenameAttrName} {
+		if v, ok := attrs.Get(name); ok {
+			if title, ok := v.([]byte); ok && len(title) != 0 {
+				return title, true
+			}
+		}
+	}
+	return nil, false
+}
+
 // WrapperRenderer renders wrapper elements like div, pre, etc.
 type WrapperRenderer func(w util.BufWriter, context CodeBlockContext, entering bool)
 
+// TitleRenderer renders a title header of a code block.
+type TitleRenderer func(w util.BufWriter, context CodeBlockContext, title []byte)
+
+// DefaultTitleRenderer renders a title as a div element that has a
+// "code-title" class.
+func DefaultTitleRenderer(w util.BufWriter, context CodeBlockContext, title []byte) {
+	_, _ = w.WriteString(`<div class="code-title">`)
+	_, _ = w.Write(util.EscapeHTML(title))
+	_, _ = w.WriteString("</div>\n")
+}
+
+// WrapperRendererE is a WrapperRenderer that can fail. When entering, this
+// can return ast.WalkSkipChildren to skip the highlighted code, so the
+// wrapper can render its own output instead. ast.WalkStop and errors abort
+// the conversion with a HighlightError. If this returns ast.WalkStop without
+// an error, the underlying error is ErrRenderingStopped.
+type WrapperRendererE func(w util.BufWriter, context CodeBlockContext, entering bool) (ast.WalkStatus, error)
+
 // CodeBlockOptions creates Chroma options per code block.
 type CodeBlockOptions func(ctx CodeBlockContext) []chromahtml.Option
 
+// CodeBlockOptionsE is a CodeBlockOptions that can fail. Errors abort the
+// conversion.
+type CodeBlockOptionsE func(ctx CodeBlockContext) ([]chromahtml.Option, error)
+
 // Config struct holds options for the extension.
 type Config struct {
 	html.Config
@@ -129,11 +253,157 @@ type Config struct {
 	// If WithClasses() is enabled, you can get CSS data corresponds to the style.
 	CSSWriter io.Writer
 
+	// StyleSheet collects CSS data of styles used in conversions without
+	// duplicates. If this is set and WithClasses() is enabled, classes of
+	// styles other than the default style are prefixed with the style name
+	// so that several styles can coexist on one page.
+	StyleSheet *StyleSheet
+
+	// DarkStyle is a highlighting style used in the dark mode.
+	// CSS data of this style is written only if WithClasses() is enabled.
+	DarkStyle string
+
+	// DarkCustomStyle is a custom Chroma style used in the dark mode.
+	// If this is not nil, the DarkStyle string will be ignored.
+	DarkCustomStyle *chroma.Style
+
+	// DarkMode specifies how CSS data of the dark style is applied.
+	// Defaults to DarkModeMediaQuery.
+	DarkMode DarkMode
+
+	// DarkModeSelector is a CSS selector used with DarkModeSelector.
+	// Defaults to DefaultDarkModeSelector.
+	DarkModeSelector string
+
+	// ErrorPolicy specifies how errors occurred while highlighting are handled.
+	ErrorPolicy ErrorPolicy
+
+	// ErrorHandler receives errors when ErrorPolicy is ErrorPolicyReport.
+	// Errors of included files are passed with any ErrorPolicy other than
+	// ErrorPolicyFail.
+	ErrorHandler ErrorHandler
+
+	// Concurrency is the number of goroutines used for highlighting code
+	// blocks in a document. If this is greater than 1, all code blocks are
+	// highlighted concurrently before the document is rendered.
+	// CodeBlockOptions is then called for all code blocks in the document
+	// order before WrapperRenderer is called for any of them. This takes
+	// effect only when the renderer is added by NewHighlighting.
+	Concurrency int
+
+	// Cache is a cache of highlighted code.
+	Cache Cache
+
+	// CacheSalt is added to keys of the Cache. This should be changed when
+	// lexers whose changes keys can not detect, like custom lexers, are
+	// changed.
+	CacheSalt string
+
+	// Styles are styles private to the extension. Keys are lower-cased style
+	// names. These are consulted before the global chroma styles.
+	Styles map[string]*chroma.Style
+
+	// Lexers is a lexer registry private to the extension. This is consulted
+	// before the global chroma lexer registry.
+	Lexers *chroma.LexerRegistry
+
+	// LanguageAliases maps lower-cased language names in info strings to
+	// chroma lexer names.
+	LanguageAliases map[string]string
+
+	// LanguageAliasLexers maps lower-cased language names in info strings to
+	// chroma lexers. This takes precedence over LanguageAliases.
+	LanguageAliasLexers map[string]chroma.Lexer
+
+	// UnknownLanguageHandler receives code whose language is not supported
+	// by any lexers.
+	UnknownLanguageHandler UnknownLanguageHandler
+
 	// CodeBlockOptions allows set Chroma options per code block.
 	CodeBlockOptions CodeBlockOptions
 
+	// CodeBlockOptionsE is a CodeBlockOptions that can fail. This is called
+	// after CodeBlockOptions.
+	CodeBlockOptionsE CodeBlockOptionsE
+
 	// WrapperRenderer allows you to change wrapper elements.
 	WrapperRenderer WrapperRenderer
+
+	// WrapperRendererE is a WrapperRenderer that can fail and skip the
+	// highlighted code. This takes precedence over WrapperRenderer.
+	WrapperRendererE WrapperRendererE
+
+	// Titles enables title headers of code blocks that have a title or
+	// filename attribute.
+	Titles bool
+
+	// TitleRenderer renders title headers if Titles is enabled.
+	// Defaults to DefaultTitleRenderer.
+	TitleRenderer TitleRenderer
+
+	// LineAnchors enables linkable line numbers. Anchors of lines are
+	// prefixed with an id attribute, a title or an ordinal of the code
+	// block, so they are unique in a document.
+	LineAnchors bool
+
+	// Callouts enables callout markers like `// <1>` at ends of lines.
+	// Markers are rendered as badges that link to items of an ordered
+	// list following the code block.
+	Callouts bool
+
+	// CalloutPatterns holds regular expressions of callout markers per
+	// language. Defaults to DefaultCalloutPattern.
+	CalloutPatterns map[string]*regexp.Regexp
+
+	// HighlightMarkers enables marker comments like
+	// `// highlight-next-line`, `// highlight-start` and
+	// `// highlight-end` that highlight lines. Code blocks can override
+	// this with an hl_markers attribute like {hl_markers=true}.
+	HighlightMarkers bool
+
+	// MarkElement is a name of elements that wrap substrings emphasized by
+	// a mark attribute or /term/ in info strings.
+	// Defaults to DefaultMarkElement.
+	MarkElement string
+
+	// Files is a file system that code blocks include code from by a file
+	// attribute. If Files is nil, file attributes are ignored.
+	Files fs.FS
+
+	// CodeGroups enables code groups. Consecutive fenced code blocks that
+	// have the same group attribute are rendered as tabs.
+	CodeGroups bool
+
+	// CopyButtons enables buttons that copy code of code blocks.
+	CopyButtons bool
+
+	// CopyButtonRenderer renders copy buttons if CopyButtons is enabled.
+	// Defaults to DefaultCopyButtonRenderer.
+	CopyButtonRenderer CopyButtonRenderer
+
+	// ShellSessions enables the session mode of code blocks like ```console.
+	// Commands are highlighted with shell lexers, prompts and output lines
+	// are not selectable.
+	ShellSessions bool
+
+	// PromptPattern is a regular expression of prompts in shell sessions.
+	// Defaults to DefaultPromptPattern.
+	PromptPattern *regexp.Regexp
+
+	// CodeSpans enables highlighting of inline code spans that have a
+	// language hint like `code`{:go} or `code`{lang=go}.
+	CodeSpans bool
+
+	// IndentedCodeBlocks enables highlighting of indented code blocks.
+	IndentedCodeBlocks bool
+
+	// IndentedCodeLanguage is a language used for indented code blocks.
+	// If this is empty, indented code blocks are highlighted only when
+	// GuessLanguage is enabled.
+	IndentedCodeLanguage string
+
+	// TerminalColors is a color depth used by the TerminalRenderer.
+	TerminalColors TerminalColors
 }
 
 // NewConfig returns a new Config with defaults.
@@ -145,6 +415,7 @@ func NewConfig() Config {
 		CSSWriter:        nil,
 		WrapperRenderer:  nil,
 		CodeBlockOptions: nil,
+		TerminalColors:   Terminal256,
 	}
 }
 
@@ -161,12 +432,80 @@ func (c *Config) SetOption(name renderer.OptionName, value interface{}) {
 		}
 	case optCSSWriter:
 		c.CSSWriter = value.(io.Writer)
+	case optStyleSheet:
+		c.StyleSheet = value.(*StyleSheet)
+	case optErrorPolicy:
+		c.ErrorPolicy = value.(ErrorPolicy)
+	case optErrorHandler:
+		c.ErrorHandler = value.(ErrorHandler)
+	case optConcurrency:
+		c.Concurrency = value.(int)
+	case optCache:
+		c.Cache = value.(Cache)
+	case optCacheSalt:
+		c.CacheSalt = value.(string)
+	case optStyles:
+		c.Styles = value.(map[string]*chroma.Style)
+	case optLexers:
+		c.Lexers = value.(*chroma.LexerRegistry)
+	case optLanguageAliases:
+		c.LanguageAliases = value.(map[string]string)
+	case optLanguageAliasLexers:
+		c.LanguageAliasLexers = value.(map[string]chroma.Lexer)
+	case optUnknownLanguageHandler:
+		c.UnknownLanguageHandler = value.(UnknownLanguageHandler)
+	case optDarkStyle:
+		c.DarkStyle = value.(string)
+	case optDarkCustomStyle:
+		c.DarkCustomStyle = value.(*chroma.Style)
+	case optDarkMode:
+		c.DarkMode = value.(DarkMode)
+	case optDarkModeSelector:
+		c.DarkModeSelector = value.(string)
 	case optWrapperRenderer:
 		c.WrapperRenderer = value.(WrapperRenderer)
 	case optCodeBlockOptions:
 		c.CodeBlockOptions = value.(CodeBlockOptions)
+	case optWrapperRendererE:
+		c.WrapperRendererE = value.(WrapperRendererE)
+	case optCodeBlockOptionsE:
+		c.CodeBlockOptionsE = value.(CodeBlockOptionsE)
 	case optGuessLanguage:
 		c.GuessLanguage = value.(bool)
+	case optTitles:
+		c.Titles = value.(bool)
+	case optTitleRenderer:
+		c.TitleRenderer = value.(TitleRenderer)
+	case optLineAnchors:
+		c.LineAnchors = value.(bool)
+	case optCallouts:
+		c.Callouts = value.(bool)
+	case optCalloutPatterns:
+		c.CalloutPatterns = value.(map[string]*regexp.Regexp)
+	case optHighlightMarkers:
+		c.HighlightMarkers = value.(bool)
+	case optMarkElement:
+		c.MarkElement = value.(string)
+	case optFiles:
+		c.Files = value.(fs.FS)
+	case optCodeGroups:
+		c.CodeGroups = value.(bool)
+	case optCopyButtons:
+		c.CopyButtons = value.(bool)
+	case optCopyButtonRenderer:
+		c.CopyButtonRenderer = value.(CopyButtonRenderer)
+	case optShellSessions:
+		c.ShellSessions = value.(bool)
+	case optPromptPattern:
+		c.PromptPattern = value.(*regexp.Regexp)
+	case optCodeSpans:
+		c.CodeSpans = value.(bool)
+	case optIndentedCodeBlocks:
+		c.IndentedCodeBlocks = value.(bool)
+	case optIndentedCodeLanguage:
+		c.IndentedCodeLanguage = value.(string)
+	case optTerminalColors:
+		c.TerminalColors = value.(TerminalColors)
 	default:
 		c.Config.SetOption(name, value)
 	}
@@ -215,6 +554,8 @@ var linenosAttrName = []byte("linenos")
 var linenosTableAttrValue = []byte("table")
 var linenosInlineAttrValue = []byte("inline")
 var linenostartAttrName = []byte("linenostart")
+var titleAttrName = []byte("title")
+var filenameAttrName = []byte("filename")
 
 type withStyle struct {
 	value string
@@ -329,6 +670,146 @@ func WithCodeBlockOptions(c CodeBlockOptions) Option {
 	return &withCodeBlockOptions{value: c}
 }
 
+const optWrapperRendererE renderer.OptionName = "HighlightingWrapperRendererE"
+
+type withWrapperRendererE struct {
+	value WrapperRendererE
+}
+
+func (o *withWrapperRendererE) SetConfig(c *renderer.Config) {
+	c.Options[optWrapperRendererE] = o.value
+}
+
+func (o *withWrapperRendererE) SetHighlightingOption(c *Config) {
+	c.WrapperRendererE = o.value
+}
+
+// WithWrapperRendererE is a functional option that sets WrapperRendererE that
+// renders wrapper elements and can replace highlighted code.
+func WithWrapperRendererE(w WrapperRendererE) Option {
+	return &withWrapperRendererE{w}
+}
+
+const optCodeBlockOptionsE renderer.OptionName = "HighlightingCodeBlockOptionsE"
+
+type withCodeBlockOptionsE struct {
+	value CodeBlockOptionsE
+}
+
+func (o *withCodeBlockOptionsE) SetConfig(c *renderer.Config) {
+	c.Options[optCodeBlockOptionsE] = o.value
+}
+
+func (o *withCodeBlockOptionsE) SetHighlightingOption(c *Config) {
+	c.CodeBlockOptionsE = o.value
+}
+
+// WithCodeBlockOptionsE is a functional option that sets CodeBlockOptionsE
+// that allows setting Chroma options per code block and can fail.
+func WithCodeBlockOptionsE(c CodeBlockOptionsE) Option {
+	return &withCodeBlockOptionsE{value: c}
+}
+
+const optTitles renderer.OptionName = "HighlightingTitles"
+
+type withTitles struct {
+	value bool
+}
+
+func (o *withTitles) SetConfig(c *renderer.Config) {
+	c.Options[optTitles] = o.value
+}
+
+func (o *withTitles) SetHighlightingOption(c *Config) {
+	c.Titles = o.value
+}
+
+// WithTitles is a functional option that toggles title headers of code
+// blocks that have a title or filename attribute.
+func WithTitles(b bool) Option {
+	return &withTitles{value: b}
+}
+
+const optTitleRenderer renderer.OptionName = "HighlightingTitleRenderer"
+
+type withTitleRenderer struct {
+	value TitleRenderer
+}
+
+func (o *withTitleRenderer) SetConfig(c *renderer.Config) {
+	c.Options[optTitleRenderer] = o.value
+}
+
+func (o *withTitleRenderer) SetHighlightingOption(c *Config) {
+	c.TitleRenderer = o.value
+}
+
+// WithTitleRenderer is a functional option that sets a TitleRenderer that
+// renders title headers of code blocks.
+func WithTitleRenderer(t TitleRenderer) Option {
+	return &withTitleRenderer{t}
+}
+
+const optCodeSpans renderer.OptionName = "HighlightingCodeSpans"
+
+type withCodeSpans struct {
+	value bool
+}
+
+func (o *withCodeSpans) SetConfig(c *renderer.Config) {
+	c.Options[optCodeSpans] = o.value
+}
+
+func (o *withCodeSpans) SetHighlightingOption(c *Config) {
+	c.CodeSpans = o.value
+}
+
+// WithCodeSpans is a functional option that toggles highlighting of
+// inline code spans that have a language hint.
+func WithCodeSpans(b bool) Option {
+	return &withCodeSpans{value: b}
+}
+
+const optIndentedCodeBlocks renderer.OptionName = "HighlightingIndentedCodeBlocks"
+
+type withIndentedCodeBlocks struct {
+	value bool
+}
+
+func (o *withIndentedCodeBlocks) SetConfig(c *renderer.Config) {
+	c.Options[optIndentedCodeBlocks] = o.value
+}
+
+func (o *withIndentedCodeBlocks) SetHighlightingOption(c *Config) {
+	c.IndentedCodeBlocks = o.value
+}
+
+// WithIndentedCodeBlocks is a functional option that toggles highlighting of
+// indented code blocks.
+func WithIndentedCodeBlocks(b bool) Option {
+	return &withIndentedCodeBlocks{value: b}
+}
+
+const optIndentedCodeLanguage renderer.OptionName = "HighlightingIndentedCodeLanguage"
+
+type withIndentedCodeLanguage struct {
+	value string
+}
+
+func (o *withIndentedCodeLanguage) SetConfig(c *renderer.Config) {
+	c.Options[optIndentedCodeLanguage] = o.value
+}
+
+func (o *withIndentedCodeLanguage) SetHighlightingOption(c *Config) {
+	c.IndentedCodeLanguage = o.value
+}
+
+// WithIndentedCodeLanguage is a functional option that sets a language
+// used for indented code blocks.
+func WithIndentedCodeLanguage(language string) Option {
+	return &withIndentedCodeLanguage{value: language}
+}
+
 const optFormatOptions renderer.OptionName = "HighlightingFormatOptions"
 
 type withFormatOptions struct {
@@ -351,9 +832,164 @@ func WithFormatOptions(opts ...chromahtml.Option) Option {
 	return &withFormatOptions{opts}
 }
 
+func (c *Config) defaultStyle() *chroma.Style {
+	if c.CustomStyle != nil {
+		return c.CustomStyle
+	}
+	return c.getStyle(c.Style)
+}
+
+// codeBlockSettings holds highlighting settings that are specified by
+// attributes of a code block.
+type codeBlockSettings struct {
+	baseLineNumber     int
+	hasBaseLineNumber  bool
+	highlightLines     [][2]int
+	hasHighlightLines  bool
+	highlightPattern   *regexp.Regexp
+	highlightMarkers   bool
+	style              *chroma.Style
+	nohl               bool
+	lineNumbers        chroma.Trilean
+	lineNumbersInTable chroma.Trilean
+
+	// err is an error of invalid settings.
+	err error
+}
+
+func (c *Config) newCodeBlockSettings(attrs ImmutableAttributes) codeBlockSettings {
+	s := codeBlockSettings{
+		baseLineNumber:   1,
+		highlightMarkers: c.HighlightMarkers,
+	}
+	if attrs == nil {
+		return s
+	}
+	if linenostartAttr, ok := attrs.Get(linenostartAttrName); ok {
+		if linenostart, ok := linenostartAttr.(float64); ok {
+			s.baseLineNumber = int(linenostart)
+			s.hasBaseLineNumber = true
+		}
+	}
+	if linesAttr, hasLinesAttr := attrs.Get(highlightLinesAttrName); hasLinesAttr {
+		if lines, ok := linesAttr.([]interface{}); ok {
+			s.hasHighlightLines = true
+			for _, l := range lines {
+				if ln, ok := l.(float64); ok {
+					s.highlightLines = append(s.highlightLines, [2]int{int(ln) + s.baseLineNumber - 1, int(ln) + s.baseLineNumber - 1})
+				}
+				if rng, ok := l.([]uint8); ok {
+					slices := strings.Split(string([]byte(rng)), "-")
+					lhs, err := strconv.Atoi(slices[0])
+					if err != nil {
+						continue
+					}
+					rhs := lhs
+					if len(slices) > 1 {
+						rhs, err = strconv.Atoi(slices[1])
+						if err != nil {
+							continue
+						}
+					}
+					s.highlightLines = append(s.highlightLines, [2]int{lhs + s.baseLineNumber - 1, rhs + s.baseLineNumber - 1})
+				}
+			}
+		}
+	}
+	if patternAttr, ok := attrs.Get(highlightPatternAttrName); ok {
+		if pattern, ok := patternAttr.([]byte); ok {
+			re, err := regexp.Compile(string(pattern))
+			if err != nil {
+				s.err = fmt.Errorf("invalid hl_pattern: %w", err)
+			} else {
+				s.highlightPattern = re
+			}
+		}
+	}
+	if markersAttr, ok := attrs.Get(highlightMarkersAttrName); ok {
+		if markers, ok := markersAttr.(bool); ok {
+			s.highlightMarkers = markers
+		}
+	}
+	if styleAttr, hasStyleAttr := attrs.Get(styleAttrName); hasStyleAttr {
+		if st, ok := styleAttr.([]uint8); ok {
+			styleStr := string([]byte(st))
+			s.style = c.getStyle(styleStr)
+		}
+	}
+	if _, hasNohlAttr := attrs.Get(nohlAttrName); hasNohlAttr {
+		s.nohl = true
+	}
+	if linenosAttr, ok := attrs.Get(linenosAttrName); ok {
+		switch v := linenosAttr.(type) {
+		case bool:
+			s.lineNumbers = trilean(v)
+		case []uint8:
+			if v != nil {
+				s.lineNumbers = chroma.Yes
+			}
+			if bytes.Equal(v, linenosTableAttrValue) {
+				s.lineNumbersInTable = chroma.Yes
+			} else if bytes.Equal(v, linenosInlineAttrValue) {
+				s.lineNumbersInTable = chroma.No
+			}
+		}
+	}
+	return s
+}
+
+// htmlOptions returns chroma HTML formatter options that correspond to
+// the settings.
+func (s *codeBlockSettings) htmlOptions() []chromahtml.Option {
+	var options []chromahtml.Option
+	if s.hasBaseLineNumber {
+		options = append(options, chromahtml.BaseLineNumber(s.baseLineNumber))
+	}
+	if s.hasHighlightLines {
+		options = append(options, chromahtml.HighlightLines(s.highlightLines))
+	}
+	if s.lineNumbers != chroma.Pass {
+		options = append(options, chromahtml.WithLineNumbers(s.lineNumbers == chroma.Yes))
+	}
+	if s.lineNumbersInTable != chroma.Pass {
+		options = append(options, chromahtml.LineNumbersInTable(s.lineNumbersInTable == chroma.Yes))
+	}
+	return options
+}
+
+// key returns a string that identifies the settings in cache keys.
+func (s *codeBlockSettings) key() string {
+	pattern := ""
+	if s.highlightPattern != nil {
+		pattern = s.highlightPattern.String()
+	}
+	return fmt.Sprintf("%d:%t:%v:%t:%q:%t:%d:%d", s.baseLineNumber, s.hasBaseLineNumber,
+		s.highlightLines, s.hasHighlightLines, pattern, s.highlightMarkers, s.lineNumbers, s.lineNumbersInTable)
+}
+
+// isHighlighted returns true if the given line should be highlighted.
+func (s *codeBlockSettings) isHighlighted(line int) bool {
+	for _, rng := range s.highlightLines {
+		if line >= rng[0] && line <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func trilean(b bool) chroma.Trilean {
+	if b {
+		return chroma.Yes
+	}
+	return chroma.No
+}
+
 // HTMLRenderer struct is a renderer.NodeRenderer implementation for the extension.
 type HTMLRenderer struct {
 	Config
+
+	mu     sync.Mutex
+	states map[*ast.Document]*documentState
 }
 
 // NewHTMLRenderer builds a new HTMLRenderer with given options and returns it.
@@ -370,6 +1006,15 @@ func NewHTMLRenderer(opts ...Option) renderer.NodeRenderer {
 // RegisterFuncs implements NodeRenderer.RegisterFuncs.
 func (r *HTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+	if r.IndentedCodeBlocks {
+		reg.Register(ast.KindCodeBlock, r.renderIndentedCodeBlock)
+	}
+	if r.CodeSpans {
+		reg.Register(ast.KindCodeSpan, r.renderCodeSpan)
+	}
+	if r.CodeGroups {
+		reg.Register(KindCodeGroup, r.renderCodeGroup)
+	}
 }
 
 func getAttributes(node *ast.FencedCodeBlock, infostr []byte) ImmutableAttributes {
@@ -385,7 +1030,7 @@ func getAttributes(node *ast.FencedCodeBlock, infostr []byte) ImmutableAttribute
 				break
 			}
 		}
-		if attrStartIdx > 0 {
+		if attrStartIdx > -1 {
 			n := ast.NewTextBlock() // dummy node for storing attributes
 			attrStr := infostr[attrStartIdx:]
 			if attrs, hasAttr := parser.ParseAttributes(text.NewReader(attrStr)); hasAttr {
@@ -399,161 +1044,400 @@ func getAttributes(node *ast.FencedCodeBlock, infostr []byte) ImmutableAttribute
 	return nil
 }
 
+// fencedCodeBlockLanguage returns a language of the given fenced code block.
+// Info strings that have only attributes like {file="main.go"} have no
+// languages.
+func fencedCodeBlockLanguage(source []byte, n *ast.FencedCodeBlock) []byte {
+	language := n.Language(source)
+	if len(language) != 0 && language[0] == '{' {
+		return nil
+	}
+	return language
+}
+
+// codeBlockLines returns code of the given code block.
+func codeBlockLines(source []byte, n ast.Node) []byte {
+	var buffer bytes.Buffer
+	l := n.Lines().Len()
+	for i := 0; i < l; i++ {
+		line := n.Lines().At(i)
+		buffer.Write(line.Value(source))
+	}
+	return buffer.Bytes()
+}
+
+// codeBlockAttributes returns attributes of the given code block.
+func codeBlockAttributes(source []byte, n ast.Node) ImmutableAttributes {
+	fcb, ok := n.(*ast.FencedCodeBlock)
+	if !ok {
+		return nil
+	}
+	var info []byte
+	if fcb.Info != nil {
+		info = fcb.Info.Segment.Value(source)
+	}
+	return getAttributes(fcb, info)
+}
+
 func (r *HTMLRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
-	n := node.(*ast.FencedCodeBlock)
+	group, inGroup := node.Parent().(*CodeGroup)
 	if !entering {
+		if inGroup {
+			_, _ = w.WriteString("</div>\n")
+		}
 		return ast.WalkContinue, nil
 	}
-	language := n.Language(source)
+	if inGroup {
+		r.renderCodeGroupPanel(w, group, node)
+	}
+	return r.renderCodeBlock(w, source, r.codeBlock(source, node))
+}
+
+func (r *HTMLRenderer) renderIndentedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	return r.renderCodeBlock(w, source, r.codeBlock(source, node))
+}
+
+// codeBlock holds a code block and settings for highlighting it.
+type codeBlock struct {
+	node             ast.Node
+	language         []byte
+	originalLanguage []byte
+	attrs            ImmutableAttributes
+	detection        LanguageDetection
+	included         []byte
+	includeErr       *HighlightError
+	settingsErr      *HighlightError
+	optionsErr       *HighlightError
+	rawCode          []byte
+	line             int
+
+	// following fields are set only if the code block can be highlighted.
+	code        string
+	lexer       chroma.Lexer
+	style       *chroma.Style
+	formatter   *chromahtml.Formatter
+	settings    string
+	context     CodeBlockContext
+	decorations *decorations
+
+	// following fields are set if the code block has been highlighted
+	// in advance.
+	done        bool
+	highlighted []byte
+	err         *HighlightError
+}
+
+// newCodeBlock resolves settings for highlighting the given code block.
+func (r *HTMLRenderer) newCodeBlock(source []byte, n ast.Node, state *documentState) *codeBlock {
+	var anchorPrefixes map[ast.Node]string
+	if state != nil {
+		anchorPrefixes = state.lineAnchorPrefixes
+	}
+	var language []byte
+	if fcb, ok := n.(*ast.FencedCodeBlock); ok {
+		language = fencedCodeBlockLanguage(source, fcb)
+	} else if len(r.IndentedCodeLanguage) != 0 {
+		language = []byte(r.IndentedCodeLanguage)
+	}
+	attrs := codeBlockAttributes(source, n)
+	originalLanguage := language
+	diff := isDiff(attrs)
+	if lang, ok := r.diffLanguage(language); ok {
+		language = lang
+		diff = true
+	}
+	cb := &codeBlock{
+		node:             n,
+		language:         language,
+		originalLanguage: originalLanguage,
+		attrs:            attrs,
+		line:             codeBlockLine(source, n),
+	}
+	included, file, err := r.includeCode(attrs)
+	if err != nil {
+		cb.includeErr = newHighlightError(opInclude, originalLanguage, cb.line, err)
+	}
+	cb.included = included
+	if included != nil {
+		cb.rawCode = included
+	} else {
+		cb.rawCode = codeBlockLines(source, n)
+	}
 
 	chromaFormatterOptions := make([]chromahtml.Option, len(r.FormatOptions))
 	copy(chromaFormatterOptions, r.FormatOptions)
 
-	style := r.CustomStyle
+	settings := r.newCodeBlockSettings(attrs)
+	if settings.err != nil {
+		cb.settingsErr = newHighlightError(opConfigure, originalLanguage, cb.line, settings.err)
+	}
+	chromaFormatterOptions = append(chromaFormatterOptions, settings.htmlOptions()...)
+	if r.LineAnchors {
+		chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.LinkableLineNumbers(true, r.lineAnchorPrefix(anchorPrefixes, source, n)))
+	}
+	style := settings.style
 	if style == nil {
-		style = styles.Get(r.Style)
+		style = r.defaultStyle()
 	}
-	nohl := false
+	nohl := settings.nohl
 
-	var info []byte
-	if n.Info != nil {
-		info = n.Info.Segment.Value(source)
-	}
-	attrs := getAttributes(n, info)
-	if attrs != nil {
-		baseLineNumber := 1
-		if linenostartAttr, ok := attrs.Get(linenostartAttrName); ok {
-			if linenostart, ok := linenostartAttr.(float64); ok {
-				baseLineNumber = int(linenostart)
-				chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.BaseLineNumber(baseLineNumber))
-			}
+	var lexer chroma.Lexer
+	if language != nil {
+		lexer = r.getLexer(language)
+		if lexer != nil {
+			cb.detection = LanguageDetectionExplicit
 		}
-		if linesAttr, hasLinesAttr := attrs.Get(highlightLinesAttrName); hasLinesAttr {
-			if lines, ok := linesAttr.([]interface{}); ok {
-				var hlRanges [][2]int
-				for _, l := range lines {
-					if ln, ok := l.(float64); ok {
-						hlRanges = append(hlRanges, [2]int{int(ln) + baseLineNumber - 1, int(ln) + baseLineNumber - 1})
-					}
-					if rng, ok := l.([]uint8); ok {
-						slices := strings.Split(string([]byte(rng)), "-")
-						lhs, err := strconv.Atoi(slices[0])
-						if err != nil {
-							continue
-						}
-						rhs := lhs
-						if len(slices) > 1 {
-							rhs, err = strconv.Atoi(slices[1])
-							if err != nil {
-								continue
-							}
-						}
-						hlRanges = append(hlRanges, [2]int{lhs + baseLineNumber - 1, rhs + baseLineNumber - 1})
-					}
-				}
-				chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.HighlightLines(hlRanges))
-			}
+	}
+	if lexer == nil {
+		lexer = r.filenameLexer(attrs, file)
+		if lexer != nil {
+			cb.language = []byte(strings.ToLower(lexer.Config().Name))
+			cb.detection = LanguageDetectionFilename
 		}
-		if styleAttr, hasStyleAttr := attrs.Get(styleAttrName); hasStyleAttr {
-			if st, ok := styleAttr.([]uint8); ok {
-				styleStr := string([]byte(st))
-				style = styles.Get(styleStr)
+	}
+	if lexer == nil && language != nil && !nohl {
+		r.reportUnknownLanguage(language, cb.line)
+	}
+	if nohl || (lexer == nil && !r.GuessLanguage) {
+		return cb
+	}
+	if style == nil {
+		style = styles.Fallback
+	}
+	cb.code = string(cb.rawCode)
+	highlightLines := false
+	if settings.highlightMarkers {
+		code, lines := stripHighlightMarkers(cb.code)
+		cb.code = code
+		settings.addHighlightLines(lines)
+		highlightLines = len(lines) != 0
+	}
+	var diffMarkers string
+	if diff {
+		code, markers := stripDiff(cb.code)
+		cb.code = code
+		diffMarkers = markers
+		cb.decorations = &decorations{}
+		addDiffDecorations(cb.decorations, markers, style)
+	}
+	if r.Callouts {
+		code, callouts := stripCallouts(cb.code, r.calloutPattern(language))
+		if callouts != nil {
+			cb.code = code
+			if cb.decorations == nil {
+				cb.decorations = &decorations{}
 			}
+			addCalloutDecorations(cb.decorations, callouts, r.lineAnchorPrefix(anchorPrefixes, source, n), calloutList(n) != nil)
 		}
-		if _, hasNohlAttr := attrs.Get(nohlAttrName); hasNohlAttr {
-			nohl = true
+	}
+	cb.rawCode = []byte(stripRemovedLines(cb.code, diffMarkers))
+	if r.ShellSessions && lexer != nil && isSessionLexer(lexer) {
+		lexer = r.newSessionLexer(lexer.Config())
+		cb.rawCode = []byte(sessionCommands(string(cb.rawCode), r.promptPattern()))
+		if cb.decorations == nil {
+			cb.decorations = &decorations{}
 		}
-
-		if linenosAttr, ok := attrs.Get(linenosAttrName); ok {
-			switch v := linenosAttr.(type) {
-			case bool:
-				chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.WithLineNumbers(v))
-			case []uint8:
-				if v != nil {
-					chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.WithLineNumbers(true))
-				}
-				if bytes.Equal(v, linenosTableAttrValue) {
-					chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.LineNumbersInTable(true))
-				} else if bytes.Equal(v, linenosInlineAttrValue) {
-					chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.LineNumbersInTable(false))
-				}
-			}
+		addSessionDecorations(cb.decorations, r.promptPattern())
+	}
+	if terms := markTerms(source, n, attrs); len(terms) != 0 {
+		element := r.MarkElement
+		if len(element) == 0 {
+			element = DefaultMarkElement
+		}
+		if cb.decorations == nil {
+			cb.decorations = &decorations{}
 		}
+		addMarkDecorations(cb.decorations, terms, element)
+	}
+	if settings.highlightPattern != nil {
+		lines := matchLines(cb.code, settings.highlightPattern)
+		settings.addHighlightLines(lines)
+		highlightLines = highlightLines || len(lines) != 0
+	}
+	if highlightLines {
+		chromaFormatterOptions = append(chromaFormatterOptions, chromahtml.HighlightLines(settings.highlightLines))
 	}
 
-	var lexer chroma.Lexer
-	if language != nil {
-		lexer = lexers.Get(string(language))
+	if lexer == nil {
+		lexer = r.analyseLexer(cb.code)
+		cb.language = []byte(strings.ToLower(lexer.Config().Name))
+		cb.detection = LanguageDetectionContent
 	}
-	if !nohl && (lexer != nil || r.GuessLanguage) {
-		if style == nil {
-			style = styles.Fallback
-		}
-		var buffer bytes.Buffer
-		l := n.Lines().Len()
-		for i := 0; i < l; i++ {
-			line := n.Lines().At(i)
-			buffer.Write(line.Value(source))
-		}
+	cb.lexer = lexer
+	cb.style = style
+	cb.context = newCodeBlockContext(cb, true)
 
-		if lexer == nil {
-			lexer = lexers.Analyse(buffer.String())
-			if lexer == nil {
-				lexer = lexers.Fallback
-			}
-			language = []byte(strings.ToLower(lexer.Config().Name))
+	if r.CodeBlockOptions != nil {
+		chromaFormatterOptions = append(chromaFormatterOptions, r.CodeBlockOptions(cb.context)...)
+	}
+	if r.CodeBlockOptionsE != nil {
+		options, err := r.CodeBlockOptionsE(cb.context)
+		if err != nil {
+			cb.optionsErr = newHighlightError(opConfigure, originalLanguage, cb.line, err)
+			cb.lexer = nil
+			return cb
 		}
-		lexer = chroma.Coalesce(lexer)
+		chromaFormatterOptions = append(chromaFormatterOptions, options...)
+	}
+	cb.formatter = chromahtml.New(append(r.scopeOptions(style), chromaFormatterOptions...)...)
+	cb.settings = settings.key()
+	return cb
+}
 
-		iterator, err := lexer.Tokenise(nil, buffer.String())
-		if err == nil {
-			c := newCodeBlockContext(language, true, attrs)
+// highlight tokenizes and formats the given code. d can be nil.
+// If the Cache is set, formatted code is cached.
+func (r *HTMLRenderer) highlight(code string, lexer chroma.Lexer, style *chroma.Style, formatter *chromahtml.Formatter, settings string, d *decorations) ([]byte, *HighlightError) {
+	var key string
+	if r.Cache != nil {
+		key = cacheKey(r.CacheSalt, code, lexer, style, formatter, settings, d)
+		if highlighted, ok := r.Cache.Get(key); ok {
+			return highlighted, nil
+		}
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return nil, newHighlightError(opTokenise, nil, 0, err)
+	}
+	if d != nil {
+		iterator = chroma.Literator(d.decorate(iterator.Tokens())...)
+	}
+	var buffer bytes.Buffer
+	err = formatter.Format(&buffer, style, iterator)
+	highlighted := buffer.Bytes()
+	if d != nil {
+		highlighted = d.replace(highlighted)
+	}
+	if err != nil {
+		return highlighted, newHighlightError(opFormat, nil, 0, err)
+	}
+	if r.Cache != nil {
+		r.Cache.Set(key, highlighted)
+	}
+	return highlighted, nil
+}
 
-			if r.CodeBlockOptions != nil {
-				chromaFormatterOptions = append(chromaFormatterOptions, r.CodeBlockOptions(c)...)
+func (r *HTMLRenderer) renderCodeBlock(w util.BufWriter, source []byte, cb *codeBlock) (ast.WalkStatus, error) {
+	n := cb.node
+	if cb.optionsErr != nil {
+		return ast.WalkStop, cb.optionsErr
+	}
+	if cb.includeErr != nil {
+		if err := r.handleIncludeError(cb.includeErr); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	if cb.settingsErr != nil {
+		if err := r.handleError(cb.settingsErr); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	if cb.lexer != nil {
+		if !cb.done {
+			cb.highlighted, cb.err = r.highlight(cb.code, cb.lexer, cb.style, cb.formatter, cb.settings, cb.decorations)
+		}
+		err := cb.err
+		if err == nil || (err.Op == opFormat && r.ErrorPolicy == ErrorPolicyIgnore) {
+			r.renderTitle(w, cb.context)
+			r.renderCopyButton(w, cb.context)
+			status, err := r.renderWrapper(w, cb, cb.context, true)
+			if err != nil || status == ast.WalkStop {
+				return ast.WalkStop, err
+			}
+			skip := status == ast.WalkSkipChildren
+			if !skip {
+				_, _ = w.Write(cb.highlighted)
 			}
-			formatter := chromahtml.New(chromaFormatterOptions...)
-			if r.WrapperRenderer != nil {
-				r.WrapperRenderer(w, c, true)
+			if status, err := r.renderWrapper(w, cb, cb.context, false); err != nil || status == ast.WalkStop {
+				return ast.WalkStop, err
 			}
-			_ = formatter.Format(w, style, iterator) == nil
-			if r.WrapperRenderer != nil {
-				r.WrapperRenderer(w, c, false)
+			if skip {
+				return ast.WalkContinue, nil
 			}
-			if r.CSSWriter != nil {
-				_ = formatter.WriteCSS(r.CSSWriter, style)
+			if err := r.writeCSS(cb.formatter, cb.style, cb.decorations); err != nil {
+				if err := r.handleError(newHighlightError(opWriteCSS, cb.originalLanguage, codeBlockLine(source, n), err)); err != nil {
+					return ast.WalkStop, err
+				}
 			}
 			return ast.WalkContinue, nil
 		}
+		err.Language = cb.originalLanguage
+		err.Line = codeBlockLine(source, n)
+		if err := r.handleError(err); err != nil {
+			return ast.WalkStop, err
+		}
 	}
 
-	var c CodeBlockContext
-	if r.WrapperRenderer != nil {
-		c = newCodeBlockContext(language, false, attrs)
-		r.WrapperRenderer(w, c, true)
-	} else {
+	c := newCodeBlockContext(cb, false)
+	r.renderTitle(w, c)
+	r.renderCopyButton(w, c)
+	wrapped := r.WrapperRenderer != nil || r.WrapperRendererE != nil
+	status, err := r.renderWrapper(w, cb, c, true)
+	if err != nil || status == ast.WalkStop {
+		return ast.WalkStop, err
+	}
+	if !wrapped {
 		_, _ = w.WriteString("<pre><code")
-		language := n.Language(source)
-		if language != nil {
+		if cb.originalLanguage != nil {
 			_, _ = w.WriteString(" class=\"language-")
-			r.Writer.Write(w, language)
+			r.Writer.Write(w, cb.originalLanguage)
 			_, _ = w.WriteString("\"")
 		}
 		_ = w.WriteByte('>')
 	}
-	l := n.Lines().Len()
-	for i := 0; i < l; i++ {
-		line := n.Lines().At(i)
-		r.Writer.RawWrite(w, line.Value(source))
+	if status != ast.WalkSkipChildren {
+		if cb.included != nil {
+			r.Writer.RawWrite(w, cb.included)
+		} else {
+			r.Writer.RawWrite(w, codeBlockLines(source, n))
+		}
 	}
-	if r.WrapperRenderer != nil {
-		r.WrapperRenderer(w, c, false)
-	} else {
+	if status, err := r.renderWrapper(w, cb, c, false); err != nil || status == ast.WalkStop {
+		return ast.WalkStop, err
+	}
+	if !wrapped {
 		_, _ = w.WriteString("</code></pre>\n")
 	}
 	return ast.WalkContinue, nil
 }
 
+// renderWrapper renders wrapper elements of the code block. When entering,
+// this returns ast.WalkSkipChildren if the code should not be rendered.
+func (r *HTMLRenderer) renderWrapper(w util.BufWriter, cb *codeBlock, c CodeBlockContext, entering bool) (ast.WalkStatus, error) {
+	if r.WrapperRendererE != nil {
+		status, err := r.WrapperRendererE(w, c, entering)
+		if err == nil && status == ast.WalkStop {
+			err = ErrRenderingStopped
+		}
+		if err != nil {
+			return ast.WalkStop, newHighlightError(opWrap, cb.originalLanguage, cb.line, err)
+		}
+		return status, nil
+	}
+	if r.WrapperRenderer != nil {
+		r.WrapperRenderer(w, c, entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+// renderTitle renders a title header of the code block if it has a title.
+func (r *HTMLRenderer) renderTitle(w util.BufWriter, c CodeBlockContext) {
+	if !r.Titles {
+		return
+	}
+	title, ok := c.Title()
+	if !ok {
+		return
+	}
+	if r.TitleRenderer != nil {
+		r.TitleRenderer(w, c, title)
+	} else {
+		DefaultTitleRenderer(w, c, title)
+	}
+}
+
 type highlighting struct {
 	options []Option
 }
@@ -572,7 +1456,24 @@ func NewHighlighting(opts ...Option) goldmark.Extender {
 
 // Extend implements goldmark.Extender.
 func (e *highlighting) Extend(m goldmark.Markdown) {
+	r := NewHTMLRenderer(e.options...)
+	if r.(*HTMLRenderer).CodeSpans {
+		m.Parser().AddOptions(parser.WithASTTransformers(
+			util.Prioritized(defaultCodeSpanTransformer, 200),
+		))
+	}
+	if r.(*HTMLRenderer).CodeGroups {
+		m.Parser().AddOptions(parser.WithASTTransformers(
+			util.Prioritized(defaultCodeGroupTransformer, 200),
+		))
+	}
+	if r.(*HTMLRenderer).Callouts {
+		m.Parser().AddOptions(parser.WithASTTransformers(
+			util.Prioritized(&calloutTransformer{&r.(*HTMLRenderer).Config}, 200),
+		))
+	}
 	m.Renderer().AddOptions(renderer.WithNodeRenderers(
-		util.Prioritized(NewHTMLRenderer(e.options...), 200),
+		util.Prioritized(r, 200),
 	))
+	m.SetRenderer(&stateRenderer{m.Renderer(), r.(*HTMLRenderer)})
 }
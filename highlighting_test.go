@@ -9,6 +9,7 @@ import (
 	"github.com/alecthomas/chroma/v2"
 	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/testutil"
 	"github.com/yuin/goldmark/util"
 )
@@ -562,3 +563,154 @@ User-Agent: foo
 		t.Errorf("render mismatch, got\n%s", buffer.String())
 	}
 }
+
+func TestHighlightingIndentedCodeBlocks(t *testing.T) {
+	for i, test := range []struct {
+		options []Option
+		expect  string
+	}{
+		{nil, `<pre><code>x := 1
+</code></pre>`},
+		{[]Option{WithIndentedCodeBlocks(true)}, `<pre><code>x := 1
+</code></pre>`},
+		{[]Option{WithIndentedCodeBlocks(true), WithIndentedCodeLanguage("go")}, `<pre tabindex="0" class="chroma"><code><span class="line"><span class="cl"><span class="nx">x</span> <span class="o">:=</span> <span class="mi">1</span>
+</span></span></code></pre>`},
+		{[]Option{WithIndentedCodeBlocks(true), WithGuessLanguage(true)}, `<pre tabindex="0" class="chroma"><code><span class="line"><span class="cl">x := 1
+</span></span></code></pre>`},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(append(test.options,
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					)...),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte("    x := 1\n"), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(buffer.String()) != test.expect {
+				t.Errorf("render mismatch, got\n%s", buffer.String())
+			}
+		})
+	}
+}
+
+func TestHighlightingTitles(t *testing.T) {
+	for i, test := range []struct {
+		options []Option
+		source  string
+		expect  string
+	}{
+		{nil, "```go {title=\"main.go\"}\nx := 1\n```\n", `<pre tabindex="0" class="chroma">`},
+		{[]Option{WithTitles(true)}, "```go {title=\"<main>.go\"}\nx := 1\n```\n", `<div class="code-title">&lt;main&gt;.go</div>
+<pre tabindex="0" class="chroma">`},
+		{[]Option{WithTitles(true)}, "```unknown {filename=\"main.x\"}\nx := 1\n```\n", `<div class="code-title">main.x</div>
+<pre><code class="language-unknown">`},
+		{[]Option{WithTitles(true), WithTitleRenderer(func(w util.BufWriter, c CodeBlockContext, title []byte) {
+			language, _ := c.Language()
+			_, _ = fmt.Fprintf(w, `<figcaption data-lang="%s">%s</figcaption>`, language, title)
+		})}, "```go {title=\"main.go\",filename=\"other.go\"}\nx := 1\n```\n", `<figcaption data-lang="go">main.go</figcaption><pre tabindex="0" class="chroma">`},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(append(test.options,
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					)...),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(buffer.String(), test.expect) {
+				t.Errorf("render mismatch, got\n%s", buffer.String())
+			}
+		})
+	}
+}
+
+func TestHighlightingCodeBlockContext(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithStyle("monokai"),
+				WithWrapperRenderer(func(w util.BufWriter, c CodeBlockContext, entering bool) {
+					if !entering {
+						return
+					}
+					lexer := "none"
+					if c.Lexer() != nil {
+						lexer = c.Lexer().Config().Name
+					}
+					style := "none"
+					if c.Style() != nil {
+						style = c.Style().Name
+					}
+					_, isFenced := c.Node().(*ast.FencedCodeBlock)
+					fmt.Fprintf(w, "[%s %s %d:%d %t %q]", lexer, style, c.Line(), c.LineCount(), isFenced, c.Code())
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("# Title\n\n```go\nx := 1\ny := 2\n```\n\n```go {nohl=true}\nz\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{
+		`[Go monokai 3:2 true "x := 1\ny := 2\n"]`,
+		`[none none 8:1 true "z\n"]`,
+	} {
+		if !strings.Contains(buffer.String(), e) {
+			t.Errorf("%q should be written, got\n%s", e, buffer.String())
+		}
+	}
+}
+
+func TestHighlightingWrapperRendererE(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithWrapperRendererE(func(w util.BufWriter, c CodeBlockContext, entering bool) (ast.WalkStatus, error) {
+					language, _ := c.Language()
+					if string(language) != "mermaid" {
+						if entering {
+							_, _ = w.WriteString(`<div class="highlight">`)
+						} else {
+							_, _ = w.WriteString("</div>\n")
+						}
+						return ast.WalkContinue, nil
+					}
+					if entering {
+						_, _ = w.WriteString(`<div class="mermaid">`)
+						_, _ = w.Write(util.EscapeHTML(c.Code()))
+						return ast.WalkSkipChildren, nil
+					}
+					_, _ = w.WriteString("</div>\n")
+					return ast.WalkContinue, nil
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```mermaid\ngraph TD;\n  A-->B;\n```\n\n```go\nx := 1\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{
+		"<div class=\"mermaid\">graph TD;\n  A--&gt;B;\n</div>\n",
+		`<div class="highlight"><pre tabindex="0"`,
+	} {
+		if !strings.Contains(buffer.String(), e) {
+			t.Errorf("%q should be written, got\n%s", e, buffer.String())
+		}
+	}
+	if strings.Contains(buffer.String(), "<pre><code") {
+		t.Errorf("skipped code should not be written, got\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,152 @@
+package highlighting
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/renderer"
+)
+
+var fileAttrName = []byte("file")
+var linesAttrName = []byte("lines")
+var regionAttrName = []byte("region")
+
+// regionMarkerPattern is a regular expression of region markers like
+// `// #region name` and `// #endregion name` in included files. Markers
+// must be the only content of their lines, so ordinary comments that start
+// with "region" are not taken for markers.
+var regionMarkerPattern = regexp.MustCompile(`^\s*(?:(?://|#|--|;|%|<!--|/\*)\s*)?#(region|endregion)(?:[ \t]+([\w.-]+))?\s*(?:-->|\*/)?\s*$`)
+
+// includeCode reads code of a file specified by a file attribute from
+// Config.Files. Code can be narrowed by a region attribute and a lines
+// attribute like lines="10-30". This returns a nil code if the attributes
+// do not specify a file.
+func (c *Config) includeCode(attrs ImmutableAttributes) (code []byte, file string, err error) {
+	if attrs == nil || c.Files == nil {
+		return nil, "", nil
+	}
+	v, ok := attrs.Get(fileAttrName)
+	if !ok {
+		return nil, "", nil
+	}
+	value, ok := v.([]byte)
+	if !ok || len(value) == 0 {
+		return nil, "", nil
+	}
+	file = path.Clean(string(value))
+	if !fs.ValidPath(file) {
+		return nil, file, fmt.Errorf("invalid file path: %s", value)
+	}
+	code, err = fs.ReadFile(c.Files, file)
+	if err != nil {
+		return nil, file, err
+	}
+	if v, ok := attrs.Get(regionAttrName); ok {
+		if region, ok := v.([]byte); ok {
+			code, err = selectRegion(code, string(region))
+			if err != nil {
+				return nil, file, err
+			}
+		}
+	}
+	if v, ok := attrs.Get(linesAttrName); ok {
+		code, err = selectLines(code, v)
+		if err != nil {
+			return nil, file, err
+		}
+	}
+	return code, file, nil
+}
+
+// selectRegion returns lines between region markers of the given name.
+// Region markers in the result are removed.
+func selectRegion(code []byte, name string) ([]byte, error) {
+	var b strings.Builder
+	found := false
+	inRegion := false
+	for _, line := range strings.SplitAfter(string(code), "\n") {
+		m := regionMarkerPattern.FindStringSubmatch(line)
+		if m == nil {
+			if inRegion {
+				b.WriteString(line)
+			}
+			continue
+		}
+		if m[2] == name {
+			inRegion = m[1] == "region"
+			found = found || inRegion
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("region not found: %s", name)
+	}
+	return []byte(b.String()), nil
+}
+
+// selectLines returns lines in the given range. A range is a line number or
+// a string like "10-30", "10-" and "-30". Line numbers are 1-based. Ranges
+// out of the code are errors, so code blocks do not silently lose code when
+// files are changed.
+func selectLines(code []byte, rng interface{}) ([]byte, error) {
+	lines := strings.SplitAfter(string(code), "\n")
+	if len(lines) != 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	start, end := 1, len(lines)
+	switch v := rng.(type) {
+	case float64:
+		start, end = int(v), int(v)
+	case []byte:
+		s := strings.SplitN(string(v), "-", 2)
+		var err error
+		if len(s[0]) != 0 {
+			if start, err = strconv.Atoi(strings.TrimSpace(s[0])); err != nil {
+				return nil, fmt.Errorf("invalid line range: %s", v)
+			}
+		}
+		if len(s) == 1 {
+			end = start
+		} else if len(s[1]) != 0 {
+			if end, err = strconv.Atoi(strings.TrimSpace(s[1])); err != nil {
+				return nil, fmt.Errorf("invalid line range: %s", v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid line range: %v", v)
+	}
+	if start < 1 || start > end {
+		return nil, fmt.Errorf("invalid line range: %d-%d", start, end)
+	}
+	if end > len(lines) {
+		return nil, fmt.Errorf("line range %d-%d is out of %d lines", start, end, len(lines))
+	}
+	return []byte(strings.Join(lines[start-1:end], "")), nil
+}
+
+const optFiles renderer.OptionName = "HighlightingFiles"
+
+type withFiles struct {
+	value fs.FS
+}
+
+func (o *withFiles) SetConfig(c *renderer.Config) {
+	c.Options[optFiles] = o.value
+}
+
+func (o *withFiles) SetHighlightingOption(c *Config) {
+	c.Files = o.value
+}
+
+// WithFiles is a functional option that enables code blocks that include
+// code from files like ```go {file="examples/main.go" lines="10-30"}.
+// Files are resolved against the given file system, so code blocks can not
+// read files outside of it. If a file can not be included, the code in the
+// code block is rendered instead and the error is passed to ErrorHandler
+// unless ErrorPolicy is ErrorPolicyFail.
+func WithFiles(fsys fs.FS) Option {
+	return &withFiles{fsys}
+}
@@ -0,0 +1,145 @@
+package highlighting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+var includeFS = fstest.MapFS{
+	"examples/main.go": &fstest.MapFile{Data: []byte("package main\n\n// #region hello\nfunc hello() {}\n// #endregion hello\n\nfunc main() {}\n")},
+	"examples/util.py": &fstest.MapFile{Data: []byte("#region helpers\n# region of interest follows\ndef helper():\n    pass  # endregion\n#endregion helpers\n")},
+	"secret.txt":       &fstest.MapFile{Data: []byte("secret\n")},
+}
+
+func TestHighlightingInclude(t *testing.T) {
+	for i, test := range []struct {
+		source   string
+		expect   []string
+		unexpect []string
+	}{
+		{
+			"```{file=\"examples/main.go\" lines=\"1-1\"}\n```\n",
+			[]string{`<span class="kn">package</span> <span class="nx">main</span>`},
+			[]string{"func"},
+		},
+		{
+			"```go {file=\"./examples/main.go\" region=\"hello\"}\n```\n",
+			[]string{`<span class="kd">func</span> <span class="nf">hello</span>`},
+			[]string{"package", "region", "main"},
+		},
+		{
+			"```python {file=\"examples/util.py\" region=\"helpers\"}\n```\n",
+			[]string{
+				`<span class="c1"># region of interest follows</span>`,
+				`<span class="c1"># endregion</span>`,
+			},
+			[]string{"#region", "#endregion"},
+		},
+		{
+			"```go {file=\"examples/main.go\" lines=\"7-\"}\n```\n",
+			[]string{`<span class="nf">main</span>`},
+			[]string{"package", "hello"},
+		},
+		{
+			"```text {file=\"../secret.txt\"}\nfallback\n```\n",
+			[]string{"fallback"},
+			[]string{"secret"},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(
+						WithFiles(includeFS),
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expect {
+				if !strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should be written, got\n%s", e, buffer.String())
+				}
+			}
+			for _, e := range test.unexpect {
+				if strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should not be written, got\n%s", e, buffer.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHighlightingIncludeError(t *testing.T) {
+	markdown := goldmark.New(goldmark.WithExtensions(NewHighlighting(
+		WithFiles(includeFS),
+		WithErrorPolicy(ErrorPolicyFail),
+	)))
+	var buffer bytes.Buffer
+	err := markdown.Convert([]byte("Title\n\n```go {file=\"missing.go\"}\n```\n"), &buffer)
+	var herr *HighlightError
+	if !errors.As(err, &herr) || herr.Op != "include" || herr.Line != 3 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHighlightingIncludeErrorHandler(t *testing.T) {
+	for i, test := range []struct {
+		source string
+		expect string
+		err    string
+	}{
+		{
+			"```go {file=\"missing.go\"}\nfallback\n```\n",
+			"fallback",
+			"file does not exist",
+		},
+		{
+			"```go {file=\"examples/main.go\" region=\"missing\"}\n```\n",
+			"<pre",
+			"region not found: missing",
+		},
+		{
+			"```go {file=\"secret.txt\" lines=\"5-9\"}\n```\n",
+			"<pre",
+			"line range 5-9 is out of 1 lines",
+		},
+		{
+			"```go {file=\"secret.txt\" lines=\"0\"}\n```\n",
+			"<pre",
+			"invalid line range: 0-0",
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var errs []*HighlightError
+			markdown := goldmark.New(goldmark.WithExtensions(NewHighlighting(
+				WithFiles(includeFS),
+				WithErrorHandler(func(err *HighlightError) {
+					errs = append(errs, err)
+				}),
+			)))
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buffer.String(), test.expect) {
+				t.Errorf("%q should be written, got\n%s", test.expect, buffer.String())
+			}
+			if len(errs) != 1 || errs[0].Op != "include" || !strings.Contains(errs[0].Error(), test.err) {
+				t.Errorf("an error %q should be reported, got %v", test.err, errs)
+			}
+		})
+	}
+}
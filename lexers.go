@@ -0,0 +1,195 @@
+package highlighting
+
+import (
+	"path"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// LanguageDetection specifies how a language of a code block is detected.
+type LanguageDetection int
+
+const (
+	// LanguageDetectionNone means that the code block has no language.
+	LanguageDetectionNone LanguageDetection = iota
+
+	// LanguageDetectionExplicit means that the language is specified by
+	// the info string or Config.IndentedCodeLanguage.
+	LanguageDetectionExplicit
+
+	// LanguageDetectionFilename means that the language is detected from
+	// a filename attribute or an included file.
+	LanguageDetectionFilename
+
+	// LanguageDetectionContent means that the language is guessed from
+	// the code.
+	LanguageDetectionContent
+)
+
+// String implements fmt.Stringer.
+func (d LanguageDetection) String() string {
+	switch d {
+	case LanguageDetectionExplicit:
+		return "explicit"
+	case LanguageDetectionFilename:
+		return "filename"
+	case LanguageDetectionContent:
+		return "content"
+	}
+	return "none"
+}
+
+// getLexer returns a lexer for the given language, or nil if no lexers
+// support the language. Aliases are resolved before looking up lexers.
+func (c *Config) getLexer(language []byte) chroma.Lexer {
+	name := strings.ToLower(string(language))
+	if lexer, ok := c.LanguageAliasLexers[name]; ok {
+		return lexer
+	}
+	if alias, ok := c.LanguageAliases[name]; ok {
+		name = alias
+	}
+	if c.Lexers != nil {
+		if lexer := c.Lexers.Get(name); lexer != nil {
+			return lexer
+		}
+	}
+	return lexers.Get(name)
+}
+
+// matchLexer returns a lexer for the given filename, or nil if no lexers
+// match the filename.
+func (c *Config) matchLexer(filename string) chroma.Lexer {
+	if c.Lexers != nil {
+		if lexer := c.Lexers.Match(filename); lexer != nil {
+			return lexer
+		}
+	}
+	return lexers.Match(filename)
+}
+
+// filenameLexer returns a lexer for a filename attribute in the given
+// attributes or the given filename of an included file, or nil if no lexers
+// match them.
+func (c *Config) filenameLexer(attrs ImmutableAttributes, file string) chroma.Lexer {
+	if len(file) != 0 {
+		if lexer := c.matchLexer(path.Base(file)); lexer != nil {
+			return lexer
+		}
+	}
+	if attrs == nil {
+		return nil
+	}
+	if v, ok := attrs.Get(filenameAttrName); ok {
+		if filename, ok := v.([]byte); ok && len(filename) != 0 {
+			return c.matchLexer(path.Base(string(filename)))
+		}
+	}
+	return nil
+}
+
+// analyseLexer returns a lexer that is most likely to support the given code.
+// This never returns nil.
+func (c *Config) analyseLexer(code string) chroma.Lexer {
+	if c.Lexers != nil {
+		if lexer := c.Lexers.Analyse(code); lexer != nil {
+			return lexer
+		}
+	}
+	if lexer := lexers.Analyse(code); lexer != nil {
+		return lexer
+	}
+	return lexers.Fallback
+}
+
+const optLexers renderer.OptionName = "HighlightingLexers"
+
+type withLexers struct {
+	value []chroma.Lexer
+}
+
+func (o *withLexers) SetConfig(c *renderer.Config) {
+	if _, ok := c.Options[optLexers]; !ok {
+		c.Options[optLexers] = chroma.NewLexerRegistry()
+	}
+	registry := c.Options[optLexers].(*chroma.LexerRegistry)
+	for _, lexer := range o.value {
+		registry.Register(lexer)
+	}
+}
+
+func (o *withLexers) SetHighlightingOption(c *Config) {
+	if c.Lexers == nil {
+		c.Lexers = chroma.NewLexerRegistry()
+	}
+	for _, lexer := range o.value {
+		c.Lexers.Register(lexer)
+	}
+}
+
+// WithLexers is a functional option that registers lexers to a lexer registry
+// private to the extension. The private registry is consulted before the
+// global chroma registry, so lexers can be added without affecting other
+// goldmark instances.
+func WithLexers(l ...chroma.Lexer) Option {
+	return &withLexers{l}
+}
+
+const optLanguageAliases renderer.OptionName = "HighlightingLanguageAliases"
+
+type withLanguageAlias struct {
+	name  string
+	value string
+}
+
+func (o *withLanguageAlias) SetConfig(c *renderer.Config) {
+	if _, ok := c.Options[optLanguageAliases]; !ok {
+		c.Options[optLanguageAliases] = map[string]string{}
+	}
+	c.Options[optLanguageAliases].(map[string]string)[strings.ToLower(o.name)] = o.value
+}
+
+func (o *withLanguageAlias) SetHighlightingOption(c *Config) {
+	if c.LanguageAliases == nil {
+		c.LanguageAliases = map[string]string{}
+	}
+	c.LanguageAliases[strings.ToLower(o.name)] = o.value
+}
+
+// WithLanguageAlias is a functional option that maps a language name in
+// info strings to a chroma lexer name.
+// The name is case-insensitive.
+func WithLanguageAlias(name, lexerName string) Option {
+	return &withLanguageAlias{name, lexerName}
+}
+
+const optLanguageAliasLexers renderer.OptionName = "HighlightingLanguageAliasLexers"
+
+type withLanguageAliasLexer struct {
+	name  string
+	value chroma.Lexer
+}
+
+func (o *withLanguageAliasLexer) SetConfig(c *renderer.Config) {
+	if _, ok := c.Options[optLanguageAliasLexers]; !ok {
+		c.Options[optLanguageAliasLexers] = map[string]chroma.Lexer{}
+	}
+	c.Options[optLanguageAliasLexers].(map[string]chroma.Lexer)[strings.ToLower(o.name)] = o.value
+}
+
+func (o *withLanguageAliasLexer) SetHighlightingOption(c *Config) {
+	if c.LanguageAliasLexers == nil {
+		c.LanguageAliasLexers = map[string]chroma.Lexer{}
+	}
+	c.LanguageAliasLexers[strings.ToLower(o.name)] = o.value
+}
+
+// WithLanguageAliasLexer is a functional option that maps a language name in
+// info strings to a chroma lexer.
+// The name is case-insensitive.
+func WithLanguageAliasLexer(name string, lexer chroma.Lexer) Option {
+	return &withLanguageAliasLexer{name, lexer}
+}
@@ -0,0 +1,124 @@
+package highlighting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/util"
+)
+
+func TestHighlightingLanguageAliases(t *testing.T) {
+	var languages []string
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithLanguageAlias("GoLang2", "go"),
+				WithLanguageAliasLexer("mygo", lexers.Get("go")),
+				WithFormatOptions(
+					chromahtml.WithClasses(true),
+				),
+				WithWrapperRenderer(func(w util.BufWriter, c CodeBlockContext, entering bool) {
+					if entering {
+						language, _ := c.Language()
+						languages = append(languages, string(language))
+					}
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```golang2\nx := 1\n```\n\n```mygo\nx := 1\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(buffer.String(), `<span class="nx">x</span> <span class="o">:=</span>`) != 2 {
+		t.Errorf("aliases should be highlighted, got\n%s", buffer.String())
+	}
+	if strings.Join(languages, ",") != "golang2,mygo" {
+		t.Errorf("original language names should be kept, got %v", languages)
+	}
+}
+
+func TestHighlightingPrivateLexers(t *testing.T) {
+	dsl := chroma.MustNewLexer(&chroma.Config{
+		Name:      "MyDSL",
+		Aliases:   []string{"dsl"},
+		Filenames: []string{"*.dsl"},
+	}, func() chroma.Rules {
+		return chroma.Rules{
+			"root": {
+				{Pattern: `\bdo\b`, Type: chroma.Keyword},
+				{Pattern: `.|\n`, Type: chroma.Text},
+			},
+		}
+	})
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithLexers(dsl),
+				WithFormatOptions(
+					chromahtml.WithClasses(true),
+				),
+			),
+		),
+	)
+	for _, language := range []string{"mydsl", "dsl"} {
+		var buffer bytes.Buffer
+		if err := markdown.Convert([]byte("```"+language+"\ndo it\n```\n"), &buffer); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buffer.String(), `<span class="k">do</span> it`) {
+			t.Errorf("%s should be highlighted by the private lexer, got\n%s", language, buffer.String())
+		}
+	}
+
+	if lexers.Get("mydsl") != nil {
+		t.Error("private lexers should not be registered to the global registry")
+	}
+	var buffer bytes.Buffer
+	if err := goldmark.New(goldmark.WithExtensions(Highlighting)).Convert([]byte("```mydsl\ndo it\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buffer.String()) != `<pre><code class="language-mydsl">do it
+</code></pre>` {
+		t.Errorf("private lexers should not leak to other instances, got\n%s", buffer.String())
+	}
+}
+
+func TestHighlightingLanguageDetection(t *testing.T) {
+	var detections []string
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithGuessLanguage(true),
+				WithFormatOptions(
+					chromahtml.WithClasses(true),
+				),
+				WithWrapperRenderer(func(w util.BufWriter, c CodeBlockContext, entering bool) {
+					if entering {
+						language, _ := c.Language()
+						detections = append(detections, string(language)+":"+c.LanguageDetection().String())
+					}
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	source := "```go\nx := 1\n```\n\n" +
+		"```{filename=\"Dockerfile\"}\nFROM golang\n```\n\n" +
+		"```unknown {filename=\"main.py\"}\nimport os\n```\n\n" +
+		"```\n#!/bin/sh\necho 1\n```\n"
+	if err := markdown.Convert([]byte(source), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `<span class="k">FROM</span>`) {
+		t.Errorf("Dockerfile should be highlighted, got\n%s", buffer.String())
+	}
+	if strings.Join(detections, ",") != "go:explicit,docker:filename,python:filename,bash:content" {
+		t.Errorf("unexpected detections: %v", detections)
+	}
+}
@@ -0,0 +1,100 @@
+package highlighting
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/renderer"
+)
+
+var highlightPatternAttrName = []byte("hl_pattern")
+var highlightMarkersAttrName = []byte("hl_markers")
+
+// DefaultHighlightMarkerPattern is a regular expression of marker comments
+// like `// highlight-next-line`, `# highlight-start` and
+// `<!-- highlight-end -->` that occupy whole lines.
+// The first submatch is a kind of the marker.
+var DefaultHighlightMarkerPattern = regexp.MustCompile(`^\s*(?://|#|--|;|%|<!--|/\*)\s*highlight-(next-line|start|end)\s*(?:-->|\*/)?\s*$`)
+
+// stripHighlightMarkers strips lines of highlight markers from the given
+// code. This returns the stripped code and 0-based indices of lines that
+// should be highlighted.
+func stripHighlightMarkers(code string) (string, []int) {
+	var b strings.Builder
+	var lines []int
+	index := 0
+	next := false
+	inRange := false
+	for _, line := range strings.SplitAfter(code, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		m := DefaultHighlightMarkerPattern.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+		if m != nil {
+			switch m[1] {
+			case "next-line":
+				next = true
+			case "start":
+				inRange = true
+			case "end":
+				inRange = false
+			}
+			continue
+		}
+		if next || inRange {
+			lines = append(lines, index)
+			next = false
+		}
+		b.WriteString(line)
+		index++
+	}
+	return b.String(), lines
+}
+
+// matchLines returns 0-based indices of lines of the given code that match
+// the given pattern.
+func matchLines(code string, pattern *regexp.Regexp) []int {
+	var lines []int
+	for i, line := range strings.SplitAfter(code, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if pattern.MatchString(strings.TrimSuffix(line, "\n")) {
+			lines = append(lines, i)
+		}
+	}
+	return lines
+}
+
+// addHighlightLines adds the given 0-based indices of lines to highlighted
+// lines.
+func (s *codeBlockSettings) addHighlightLines(lines []int) {
+	for _, index := range lines {
+		line := index + s.baseLineNumber
+		s.highlightLines = append(s.highlightLines, [2]int{line, line})
+		s.hasHighlightLines = true
+	}
+}
+
+const optHighlightMarkers renderer.OptionName = "HighlightingHighlightMarkers"
+
+type withHighlightMarkers struct {
+	value bool
+}
+
+func (o *withHighlightMarkers) SetConfig(c *renderer.Config) {
+	c.Options[optHighlightMarkers] = o.value
+}
+
+func (o *withHighlightMarkers) SetHighlightingOption(c *Config) {
+	c.HighlightMarkers = o.value
+}
+
+// WithHighlightMarkers is a functional option that enables marker comments
+// like `// highlight-next-line`, `// highlight-start` and
+// `// highlight-end` that highlight lines. Marker comments are stripped
+// from output. Code blocks can enable or disable marker comments with an
+// hl_markers attribute like {hl_markers=false}.
+func WithHighlightMarkers(b bool) Option {
+	return &withHighlightMarkers{b}
+}
@@ -0,0 +1,113 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingHighlightMarkers(t *testing.T) {
+	for i, test := range []struct {
+		markers  bool
+		source   string
+		expect   []string
+		unexpect []string
+	}{
+		{
+			true,
+			"```go\n// highlight-next-line\na := 1\nb := 2\n// highlight-start\nc := 3\nd := 4\n// highlight-end\ne := 5\n```\n",
+			[]string{
+				`<span class="line hl"><span class="cl"><span class="nx">a</span>`,
+				`<span class="line"><span class="cl"><span class="nx">b</span>`,
+				`<span class="line hl"><span class="cl"><span class="nx">c</span>`,
+				`<span class="line hl"><span class="cl"><span class="nx">d</span>`,
+				`<span class="line"><span class="cl"><span class="nx">e</span>`,
+			},
+			[]string{"highlight-"},
+		},
+		{
+			false,
+			"```go\n// highlight-next-line\na := 1\n```\n",
+			[]string{"highlight-next-line"},
+			[]string{`class="line hl"`},
+		},
+		{
+			false,
+			"```go {hl_pattern=\"^b|TODO\" hl_lines=[1]}\na := 1\nb := 2\nc := TODO\nd := 4\n```\n",
+			[]string{
+				`<span class="line hl"><span class="cl"><span class="nx">a</span>`,
+				`<span class="line hl"><span class="cl"><span class="nx">b</span>`,
+				`<span class="line hl"><span class="cl"><span class="nx">c</span>`,
+				`<span class="line"><span class="cl"><span class="nx">d</span>`,
+			},
+			nil,
+		},
+		{
+			false,
+			"```go {hl_markers=true}\n// highlight-next-line\na := 1\n```\n",
+			[]string{`<span class="line hl"><span class="cl"><span class="nx">a</span>`},
+			[]string{"highlight-"},
+		},
+		{
+			true,
+			"```go {hl_markers=false}\n// highlight-next-line\na := 1\n```\n",
+			[]string{"highlight-next-line"},
+			[]string{`class="line hl"`},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(
+						WithHighlightMarkers(test.markers),
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expect {
+				if !strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should be written, got\n%s", e, buffer.String())
+				}
+			}
+			for _, e := range test.unexpect {
+				if strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should not be written, got\n%s", e, buffer.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHighlightingHighlightPatternError(t *testing.T) {
+	var errs []*HighlightError
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithErrorPolicy(ErrorPolicyReport),
+				WithErrorHandler(func(err *HighlightError) {
+					errs = append(errs, err)
+				}),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("Title\n\n```go {hl_pattern=\"(\"}\na := 1\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Op != "configure" || errs[0].Line != 3 || !strings.Contains(errs[0].Error(), "invalid hl_pattern") {
+		t.Errorf("an invalid pattern should be reported, got %v", errs)
+	}
+	if !strings.Contains(buffer.String(), "<pre") {
+		t.Errorf("code should be written, got\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,162 @@
+package highlighting
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+)
+
+var markAttrName = []byte("mark")
+
+// DefaultMarkElement is a default name of elements that wrap emphasized
+// substrings.
+const DefaultMarkElement = "mark"
+
+var markRangePattern = regexp.MustCompile(`(?:^|\s)/((?:\\.|[^/\\])+)/`)
+
+// markTerms returns substrings that should be emphasized in the given code
+// block. Substrings are specified by a mark attribute like mark="ctx" or
+// mark=["ctx", "err"], or by /ctx/ in the info string.
+func markTerms(source []byte, n ast.Node, attrs ImmutableAttributes) []string {
+	var terms []string
+	if attrs != nil {
+		if v, ok := attrs.Get(markAttrName); ok {
+			switch value := v.(type) {
+			case []byte:
+				terms = append(terms, string(value))
+			case []interface{}:
+				for _, item := range value {
+					if term, ok := item.([]byte); ok {
+						terms = append(terms, string(term))
+					}
+				}
+			}
+		}
+	}
+	if fcb, ok := n.(*ast.FencedCodeBlock); ok && fcb.Info != nil {
+		info := fcb.Info.Segment.Value(source)
+		if i := bytes.IndexByte(info, '{'); i > -1 {
+			info = info[:i]
+		}
+		for _, m := range markRangePattern.FindAllSubmatch(info, -1) {
+			terms = append(terms, strings.Replace(string(m[1]), `\/`, "/", -1))
+		}
+	}
+	result := terms[:0]
+	for _, term := range terms {
+		if len(term) != 0 {
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+// markRanges returns merged byte ranges of the given terms in the given text.
+func markRanges(text string, terms []string) [][2]int {
+	var ranges [][2]int
+	for _, term := range terms {
+		for offset := 0; ; {
+			i := strings.Index(text[offset:], term)
+			if i < 0 {
+				break
+			}
+			ranges = append(ranges, [2]int{offset + i, offset + i + len(term)})
+			offset += i + len(term)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var merged [][2]int
+	for _, r := range ranges {
+		if len(merged) != 0 && r[0] <= merged[len(merged)-1][1] {
+			if r[1] > merged[len(merged)-1][1] {
+				merged[len(merged)-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// addMarkDecorations adds decorations that wrap the given terms in elements
+// of the given name. Tokens are split at boundaries of terms, so terms can
+// span multiple tokens without breaking their styles.
+func addMarkDecorations(d *decorations, terms []string, element string) {
+	openMark := decoration{markup: "<" + element + ">"}
+	closeMark := decoration{markup: "</" + element + ">"}
+	d.add("mark:"+element+":"+strings.Join(terms, "\x01"), func(d *decorations, lines [][]chroma.Token) [][]chroma.Token {
+		for i, line := range lines {
+			var text strings.Builder
+			for _, token := range line {
+				if token.Type != placeholderType {
+					text.WriteString(token.Value)
+				}
+			}
+			ranges := markRanges(strings.TrimSuffix(text.String(), "\n"), terms)
+			if len(ranges) == 0 {
+				continue
+			}
+			type event struct {
+				offset int
+				item   decoration
+			}
+			events := make([]event, 0, len(ranges)*2)
+			for _, r := range ranges {
+				events = append(events, event{r[0], openMark}, event{r[1], closeMark})
+			}
+			var tokens []chroma.Token
+			pos := 0
+			k := 0
+			for _, token := range line {
+				if token.Type == placeholderType {
+					tokens = append(tokens, token)
+					continue
+				}
+				value := token.Value
+				for k < len(events) && events[k].offset < pos+len(value) {
+					if cut := events[k].offset - pos; cut > 0 {
+						tokens = append(tokens, chroma.Token{Type: token.Type, Value: value[:cut]})
+						value = value[cut:]
+						pos += cut
+					}
+					tokens = append(tokens, d.placeholder(events[k].item))
+					k++
+				}
+				if len(value) != 0 {
+					tokens = append(tokens, chroma.Token{Type: token.Type, Value: value})
+					pos += len(value)
+				}
+			}
+			for ; k < len(events); k++ {
+				tokens = append(tokens, d.placeholder(events[k].item))
+			}
+			lines[i] = tokens
+		}
+		return lines
+	})
+}
+
+const optMarkElement renderer.OptionName = "HighlightingMarkElement"
+
+type withMarkElement struct {
+	value string
+}
+
+func (o *withMarkElement) SetConfig(c *renderer.Config) {
+	c.Options[optMarkElement] = o.value
+}
+
+func (o *withMarkElement) SetHighlightingOption(c *Config) {
+	c.MarkElement = o.value
+}
+
+// WithMarkElement is a functional option that sets a name of elements that
+// wrap substrings emphasized by a mark attribute or /term/ in info strings.
+func WithMarkElement(name string) Option {
+	return &withMarkElement{name}
+}
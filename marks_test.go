@@ -0,0 +1,62 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingMarks(t *testing.T) {
+	for i, test := range []struct {
+		options []Option
+		source  string
+		expect  []string
+	}{
+		{
+			nil,
+			"```go {mark=\"ctx\"}\nf(ctx, context.Background())\n```\n",
+			[]string{
+				`<span class="nf">f</span><span class="p">(</span><mark><span class="nx">ctx</span></mark><span class="p">,</span>`,
+				`<span class="nx">context</span>`,
+			},
+		},
+		{
+			nil,
+			"```go /ctx.Do/ {mark=[\"Bac\"]}\nctx.Do(context.Background())\n```\n",
+			[]string{
+				`<mark><span class="nx">ctx</span><span class="p">.</span><span class="nf">Do</span></mark>`,
+				`<span class="nf">Bac</span></mark><span class="nf">kground</span>`,
+			},
+		},
+		{
+			[]Option{WithMarkElement("em")},
+			"```go {mark=\"x\"}\nx := 1\n```\n",
+			[]string{`<em><span class="nx">x</span></em>`},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(append([]Option{
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					}, test.options...)...),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(test.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range test.expect {
+				if !strings.Contains(buffer.String(), e) {
+					t.Errorf("%q should be written, got\n%s", e, buffer.String())
+				}
+			}
+		})
+	}
+}
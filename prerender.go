@@ -0,0 +1,182 @@
+package highlighting
+
+import (
+	"io"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// prerender highlights all code blocks in the given document concurrently.
+// Settings of code blocks are resolved serially in the document order, so
+// callbacks like CodeBlockOptions are never called concurrently.
+func (r *HTMLRenderer) prerender(source []byte, doc ast.Node, state *documentState) map[ast.Node]*codeBlock {
+	blocks := map[ast.Node]*codeBlock{}
+	var queue []*codeBlock
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if n.Kind() == ast.KindFencedCodeBlock || (r.IndentedCodeBlocks && n.Kind() == ast.KindCodeBlock) {
+			cb := r.newCodeBlock(source, n, state)
+			blocks[n] = cb
+			if cb.lexer != nil {
+				queue = append(queue, cb)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	workers := r.Concurrency
+	if workers > len(queue) {
+		workers = len(queue)
+	}
+	ch := make(chan *codeBlock)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cb := range ch {
+				cb.highlighted, cb.err = r.highlight(cb.code, cb.lexer, cb.style, cb.formatter, cb.settings, cb.decorations)
+				cb.done = true
+			}
+		}()
+	}
+	for _, cb := range queue {
+		ch <- cb
+	}
+	close(ch)
+	wg.Wait()
+	return blocks
+}
+
+// documentState is state of HTMLRenderer for a document that is being
+// rendered. The state is created before the document is rendered and is not
+// modified while the document is rendered.
+type documentState struct {
+	// refs is the number of renderings of the document in progress.
+	refs int
+
+	// prerendered holds code blocks highlighted in advance.
+	prerendered map[ast.Node]*codeBlock
+
+	// lineAnchorPrefixes holds prefixes of line anchors of code blocks.
+	lineAnchorPrefixes map[ast.Node]string
+}
+
+// stateRenderer is a renderer.Renderer that keeps state of an HTMLRenderer
+// for a document while the document is rendered. The state is discarded
+// when rendering ends, even if rendering fails or is stopped.
+type stateRenderer struct {
+	renderer.Renderer
+	r *HTMLRenderer
+}
+
+// Render implements renderer.Renderer.
+func (s *stateRenderer) Render(w io.Writer, source []byte, n ast.Node) error {
+	doc := n.OwnerDocument()
+	if doc == nil {
+		return s.Renderer.Render(w, source, n)
+	}
+	s.r.beginDocument(source, doc)
+	defer s.r.endDocument(doc)
+	return s.Renderer.Render(w, source, n)
+}
+
+// beginDocument creates state for the given document if the document needs
+// it.
+func (r *HTMLRenderer) beginDocument(source []byte, doc *ast.Document) {
+	r.mu.Lock()
+	if state, ok := r.states[doc]; ok {
+		state.refs++
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	if r.Concurrency <= 1 && !r.LineAnchors && !r.Callouts {
+		return
+	}
+	state := &documentState{refs: 1}
+	if r.LineAnchors || r.Callouts {
+		state.lineAnchorPrefixes = r.lineAnchorPrefixes(source, doc)
+	}
+	if r.Concurrency > 1 {
+		state.prerendered = r.prerender(source, doc, state)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = map[*ast.Document]*documentState{}
+	}
+	if s, ok := r.states[doc]; ok {
+		s.refs++
+		return
+	}
+	r.states[doc] = state
+}
+
+// endDocument discards state for the given document when no rendering of the
+// document is in progress.
+func (r *HTMLRenderer) endDocument(doc *ast.Document) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[doc]
+	if !ok {
+		return
+	}
+	state.refs--
+	if state.refs <= 0 {
+		delete(r.states, doc)
+	}
+}
+
+// documentState returns state for the document of the given node, or nil
+// if the document does not have state.
+func (r *HTMLRenderer) documentState(n ast.Node) *documentState {
+	doc := n.OwnerDocument()
+	if doc == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.states[doc]
+}
+
+// codeBlock returns the given code block with settings for highlighting it.
+// If Concurrency is greater than 1, this returns the code block highlighted
+// before the document is rendered.
+func (r *HTMLRenderer) codeBlock(source []byte, n ast.Node) *codeBlock {
+	state := r.documentState(n)
+	if state != nil {
+		if cb, ok := state.prerendered[n]; ok {
+			return cb
+		}
+	}
+	return r.newCodeBlock(source, n, state)
+}
+
+const optConcurrency renderer.OptionName = "HighlightingConcurrency"
+
+type withConcurrency struct {
+	value int
+}
+
+func (o *withConcurrency) SetConfig(c *renderer.Config) {
+	c.Options[optConcurrency] = o.value
+}
+
+func (o *withConcurrency) SetHighlightingOption(c *Config) {
+	c.Concurrency = o.value
+}
+
+// WithConcurrency is a functional option that sets the number of goroutines
+// used for highlighting code blocks in a document. If n is greater than 1,
+// all code blocks are highlighted concurrently before the document is
+// rendered, so CodeBlockOptions is called for all code blocks before
+// WrapperRenderer is called for any of them.
+func WithConcurrency(n int) Option {
+	return &withConcurrency{n}
+}
@@ -0,0 +1,121 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+func TestHighlightingConcurrency(t *testing.T) {
+	var source bytes.Buffer
+	languages := []string{"go", "python", "bash", "unknown", "c"}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&source, "# Section %d\n\n```%s {hl_lines=[1]}\nx = %d\n```\n\n> ```go\n> y := %d\n> ```\n\n", i, languages[i%len(languages)], i, i)
+	}
+
+	render := func(concurrency int) (string, string, []string) {
+		var css bytes.Buffer
+		var contexts []string
+		markdown := goldmark.New(
+			goldmark.WithExtensions(
+				NewHighlighting(
+					WithConcurrency(concurrency),
+					WithCSSWriter(&css),
+					WithFormatOptions(
+						chromahtml.WithClasses(true),
+					),
+					WithCodeBlockOptions(func(c CodeBlockContext) []chromahtml.Option {
+						language, _ := c.Language()
+						contexts = append(contexts, string(language))
+						return nil
+					}),
+					WithWrapperRenderer(func(w util.BufWriter, c CodeBlockContext, entering bool) {
+						language, _ := c.Language()
+						if entering {
+							_, _ = w.WriteString(`<div class="` + string(language) + `">`)
+						} else {
+							_, _ = w.WriteString(`</div>`)
+						}
+					}),
+				),
+			),
+		)
+		var buffer bytes.Buffer
+		if err := markdown.Convert(source.Bytes(), &buffer); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.String(), css.String(), contexts
+	}
+
+	serialHTML, serialCSS, serialContexts := render(0)
+	concurrentHTML, concurrentCSS, concurrentContexts := render(4)
+	if serialHTML != concurrentHTML {
+		t.Error("concurrent highlighting should produce the same HTML")
+	}
+	if serialCSS != concurrentCSS {
+		t.Error("concurrent highlighting should produce the same CSS")
+	}
+	if strings.Join(serialContexts, ",") != strings.Join(concurrentContexts, ",") {
+		t.Errorf("CodeBlockOptions should be called in the document order, got\n%v\n%v", serialContexts, concurrentContexts)
+	}
+}
+
+type documentRenderer struct{}
+
+func (documentRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindDocument, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString("<!-- document -->\n")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func TestHighlightingConcurrencyDocument(t *testing.T) {
+	for i, test := range []struct {
+		source string
+		fail   bool
+	}{
+		{"```go\nx := 1\n```\n\n```go\ny := 2\n```\n", false},
+		{"```broken\nx := 1\n```\n\n```go\ny := 2\n```\n", true},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(
+						WithConcurrency(4),
+						WithErrorPolicy(ErrorPolicyFail),
+						withBrokenLexer,
+					),
+				),
+				goldmark.WithRendererOptions(
+					renderer.WithNodeRenderers(util.Prioritized(documentRenderer{}, 500)),
+				),
+			)
+			source := []byte(test.source)
+			doc := markdown.Parser().Parse(text.NewReader(source))
+			var buffer bytes.Buffer
+			err := markdown.Renderer().Render(&buffer, source, doc)
+			if (err != nil) != test.fail {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.fail && !strings.HasPrefix(buffer.String(), "<!-- document -->\n") {
+				t.Errorf("the document renderer should not be overridden, got\n%s", buffer.String())
+			}
+			if len(markdown.Renderer().(*stateRenderer).r.states) != 0 {
+				t.Error("state of the document should be discarded after rendering")
+			}
+			if len(doc.OwnerDocument().Meta()) != 0 {
+				t.Errorf("document metadata should not be modified, got %v", doc.OwnerDocument().Meta())
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package highlighting
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// DefaultPromptPattern is a default regular expression of prompts in shell
+// sessions. This matches prompts like `$ `, `# ` and `PS> ` at starts of
+// lines.
+var DefaultPromptPattern = regexp.MustCompile(`^(?:[$#]|PS[^>]*>)(?: |$)`)
+
+// promptMarkup and outputMarkup enclose prompts and output lines in shell
+// sessions, so they are not selected with commands.
+const promptMarkup = `<span class="prompt" style="-webkit-user-select:none;user-select:none">`
+const outputMarkup = `<span class="output" style="-webkit-user-select:none;user-select:none">`
+
+// isSessionLexer returns true if the given lexer highlights shell sessions.
+func isSessionLexer(lexer chroma.Lexer) bool {
+	return lexer.Config().Name == lexers.BashSession.Config().Name
+}
+
+// promptPattern returns a regular expression of prompts in shell sessions.
+func (c *Config) promptPattern() *regexp.Regexp {
+	if c.PromptPattern != nil {
+		return c.PromptPattern
+	}
+	return DefaultPromptPattern
+}
+
+// sessionPart is a command or an output line of a shell session.
+type sessionPart struct {
+	// prompt is a prompt of the command. This is empty if the part is an
+	// output line.
+	prompt string
+
+	// text is a command that can span multiple lines or an output line.
+	text string
+}
+
+// parseSession splits the given shell session into commands and output
+// lines. Lines that end with `\` or "`" are continued to next lines.
+func parseSession(code string, pattern *regexp.Regexp) []sessionPart {
+	var parts []sessionPart
+	lines := strings.SplitAfter(code, "\n")
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		i++
+		if len(line) == 0 {
+			continue
+		}
+		loc := pattern.FindStringIndex(strings.TrimSuffix(line, "\n"))
+		if loc == nil || loc[0] != 0 || loc[1] == 0 {
+			parts = append(parts, sessionPart{text: line})
+			continue
+		}
+		part := sessionPart{prompt: line[:loc[1]], text: line[loc[1]:]}
+		for i < len(lines) && len(lines[i]) != 0 && isContinued(part.text) {
+			part.text += lines[i]
+			i++
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// isContinued returns true if the given command is continued to the next
+// line.
+func isContinued(command string) bool {
+	command = strings.TrimRight(command, "\r\n")
+	return strings.HasSuffix(command, `\`) || strings.HasSuffix(command, "`")
+}
+
+// sessionCommands returns commands of the given shell session without
+// prompts and output lines.
+func sessionCommands(code string, pattern *regexp.Regexp) string {
+	var b strings.Builder
+	for _, part := range parseSession(code, pattern) {
+		if len(part.prompt) != 0 {
+			b.WriteString(part.text)
+		}
+	}
+	return b.String()
+}
+
+// sessionLexer is a chroma.Lexer that highlights commands in shell sessions
+// with shell lexers. Prompts are tokenised as GenericPrompt and output lines
+// as GenericOutput.
+type sessionLexer struct {
+	config     *chroma.Config
+	pattern    *regexp.Regexp
+	shell      chroma.Lexer
+	powershell chroma.Lexer
+	analyser   func(text string) float32
+}
+
+// newSessionLexer returns a new sessionLexer that has the given config.
+func (c *Config) newSessionLexer(config *chroma.Config) chroma.Lexer {
+	return &sessionLexer{
+		config:     config,
+		pattern:    c.promptPattern(),
+		shell:      c.getLexer([]byte("bash")),
+		powershell: c.getLexer([]byte("powershell")),
+	}
+}
+
+// Config implements chroma.Lexer.
+func (l *sessionLexer) Config() *chroma.Config {
+	return l.config
+}
+
+// Tokenise implements chroma.Lexer.
+func (l *sessionLexer) Tokenise(options *chroma.TokeniseOptions, text string) (chroma.Iterator, error) {
+	var tokens []chroma.Token
+	for _, part := range parseSession(text, l.pattern) {
+		if len(part.prompt) == 0 {
+			tokens = append(tokens, chroma.Token{Type: chroma.GenericOutput, Value: part.text})
+			continue
+		}
+		tokens = append(tokens, chroma.Token{Type: chroma.GenericPrompt, Value: part.prompt})
+		lexer := l.shell
+		if strings.HasPrefix(part.prompt, "PS") && l.powershell != nil {
+			lexer = l.powershell
+		}
+		if lexer == nil {
+			tokens = append(tokens, chroma.Token{Type: chroma.Text, Value: part.text})
+			continue
+		}
+		iterator, err := lexer.Tokenise(nil, part.text)
+		if err != nil {
+			return nil, err
+		}
+		command := iterator.Tokens()
+		// lexers may ensure a trailing newline.
+		if !strings.HasSuffix(part.text, "\n") && len(command) != 0 {
+			last := &command[len(command)-1]
+			last.Value = strings.TrimSuffix(last.Value, "\n")
+		}
+		tokens = append(tokens, command...)
+	}
+	return chroma.Literator(tokens...), nil
+}
+
+// SetRegistry implements chroma.Lexer.
+func (l *sessionLexer) SetRegistry(registry *chroma.LexerRegistry) chroma.Lexer {
+	return l
+}
+
+// SetAnalyser implements chroma.Lexer.
+func (l *sessionLexer) SetAnalyser(analyser func(text string) float32) chroma.Lexer {
+	l.analyser = analyser
+	return l
+}
+
+// AnalyseText implements chroma.Lexer.
+func (l *sessionLexer) AnalyseText(text string) float32 {
+	if l.analyser != nil {
+		return l.analyser(text)
+	}
+	return 0
+}
+
+// addSessionDecorations adds decorations that make prompts and output lines
+// of shell sessions unselectable. Tokens are made by a sessionLexer.
+func addSessionDecorations(d *decorations, pattern *regexp.Regexp) {
+	d.add("session:"+pattern.String(), func(d *decorations, lines [][]chroma.Token) [][]chroma.Token {
+		for i, line := range lines {
+			// placeholders at starts of lines decorate the lines, and
+			// splitting tokens into lines can leave empty tokens.
+			start := 0
+			for start < len(line) && (line[start].Type == placeholderType || len(line[start].Value) == 0) {
+				start++
+			}
+			if start == len(line) {
+				continue
+			}
+			var markup string
+			end := start + 1
+			switch line[start].Type {
+			case chroma.GenericPrompt:
+				markup = promptMarkup
+			case chroma.GenericOutput:
+				markup = outputMarkup
+				end = len(line)
+			default:
+				continue
+			}
+			tokens := make([]chroma.Token, 0, len(line)+2)
+			tokens = append(tokens, line[:start]...)
+			tokens = append(tokens, d.placeholder(decoration{markup: markup}))
+			// chroma splits tokens into lines again, so markup must be closed
+			// before newlines.
+			tokens = appendToLine(append(tokens, line[start:end]...), d.placeholder(decoration{markup: "</span>"}))
+			lines[i] = append(tokens, line[end:]...)
+		}
+		return lines
+	})
+}
+
+const optShellSessions renderer.OptionName = "HighlightingShellSessions"
+
+type withShellSessions struct {
+	value bool
+}
+
+func (o *withShellSessions) SetConfig(c *renderer.Config) {
+	c.Options[optShellSessions] = o.value
+}
+
+func (o *withShellSessions) SetHighlightingOption(c *Config) {
+	c.ShellSessions = o.value
+}
+
+// WithShellSessions is a functional option that enables the session mode of
+// code blocks like ```console. Commands are highlighted with shell lexers,
+// prompts and output lines are not selectable, so copied text has only
+// commands.
+func WithShellSessions(b bool) Option {
+	return &withShellSessions{b}
+}
+
+const optPromptPattern renderer.OptionName = "HighlightingPromptPattern"
+
+type withPromptPattern struct {
+	value *regexp.Regexp
+}
+
+func (o *withPromptPattern) SetConfig(c *renderer.Config) {
+	c.Options[optPromptPattern] = o.value
+}
+
+func (o *withPromptPattern) SetHighlightingOption(c *Config) {
+	c.PromptPattern = o.value
+}
+
+// WithPromptPattern is a functional option that sets a regular expression of
+// prompts in shell sessions. The pattern should match prompts at starts of
+// lines.
+func WithPromptPattern(pattern *regexp.Regexp) Option {
+	return &withPromptPattern{pattern}
+}
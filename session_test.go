@@ -0,0 +1,84 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestHighlightingShellSessions(t *testing.T) {
+	for i, c := range []struct {
+		options  []Option
+		source   string
+		expect   []string
+		unexpect []string
+	}{
+		{
+			source: "```console\n$ echo \"hi\"\nhi\n```\n",
+			expect: []string{
+				`<span class="prompt" style="-webkit-user-select:none;user-select:none"><span class="gp">$ </span></span><span class="nb">echo</span> <span class="s2">&#34;hi&#34;</span>`,
+				`<span class="output" style="-webkit-user-select:none;user-select:none"><span class="go">hi</span></span>`,
+				"data-code=\"echo &quot;hi&quot;\n\"",
+			},
+		},
+		{
+			source: "```console\n$ ls \\\n  -l\ntotal 0\nPS> Get-Item .\n```\n",
+			expect: []string{
+				"data-code=\"ls \\\n  -l\nGet-Item .\n\"",
+				`<span class="gp">PS&gt; </span></span><span class="nb">Get-Item</span>`,
+			},
+			unexpect: []string{
+				`<span class="output" style="-webkit-user-select:none;user-select:none"><span class="w">  </span>`,
+			},
+		},
+		{
+			options: []Option{WithPromptPattern(regexp.MustCompile(`^\w+@\w+:\S*\$ `))},
+			source:  "```console\nuser@host:~$ pwd\n/home/user\n$ ls\n```\n",
+			expect: []string{
+				`<span class="gp">user@host:~$ </span></span><span class="nb">pwd</span>`,
+				"data-code=\"pwd\n\"",
+			},
+		},
+		{
+			options: []Option{WithShellSessions(false)},
+			source:  "```console\n$ ls\n```\n",
+			unexpect: []string{
+				`class="prompt"`,
+			},
+		},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewHighlighting(append([]Option{
+						WithShellSessions(true),
+						WithCopyButtons(true),
+						WithFormatOptions(
+							chromahtml.WithClasses(true),
+						),
+					}, c.options...)...),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte(c.source), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			output := buffer.String()
+			for _, e := range c.expect {
+				if !strings.Contains(output, e) {
+					t.Errorf("%q should be written, got\n%s", e, output)
+				}
+			}
+			for _, u := range c.unexpect {
+				if strings.Contains(output, u) {
+					t.Errorf("%q should not be written, got\n%s", u, output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package highlighting
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// StyleSheet collects CSS data of styles used in conversions without
+// duplicates.
+//
+// StyleSheet is safe for concurrent use by multiple goroutines.
+type StyleSheet struct {
+	mu    sync.Mutex
+	rules []string
+	seen  map[string]struct{}
+}
+
+// NewStyleSheet returns a new empty StyleSheet.
+func NewStyleSheet() *StyleSheet {
+	return &StyleSheet{
+		seen: map[string]struct{}{},
+	}
+}
+
+// Add adds CSS rules generated by the given formatter and style.
+// Rules that have already been added are ignored.
+func (s *StyleSheet) Add(formatter *chromahtml.Formatter, style *chroma.Style) error {
+	var buffer bytes.Buffer
+	if err := formatter.WriteCSS(&buffer, style); err != nil {
+		return err
+	}
+	s.AddCSS(buffer.String())
+	return nil
+}
+
+// AddCSS adds the given CSS rules. Each line of the css is treated as a rule.
+// Rules that have already been added are ignored.
+func (s *StyleSheet) AddCSS(css string) {
+	// chroma does not write a new line after some rules.
+	css = strings.Replace(css, "}/* ", "}\n/* ", -1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen == nil {
+		s.seen = map[string]struct{}{}
+	}
+	for _, rule := range strings.Split(css, "\n") {
+		rule = strings.TrimSpace(rule)
+		if len(rule) == 0 {
+			continue
+		}
+		if _, ok := s.seen[rule]; ok {
+			continue
+		}
+		s.seen[rule] = struct{}{}
+		s.rules = append(s.rules, rule)
+	}
+}
+
+// WriteTo writes collected CSS rules to the given writer.
+func (s *StyleSheet) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, s.String())
+	return int64(n), err
+}
+
+// String returns collected CSS rules.
+func (s *StyleSheet) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, rule := range s.rules {
+		b.WriteString(rule)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Reset discards all collected CSS rules.
+func (s *StyleSheet) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = nil
+	s.seen = map[string]struct{}{}
+}
+
+// StyleClassPrefix returns a CSS class prefix used for scoping the given style.
+func StyleClassPrefix(style *chroma.Style) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(style.Name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	b.WriteByte('-')
+	return b.String()
+}
+
+const optStyleSheet renderer.OptionName = "HighlightingStyleSheet"
+
+type withStyleSheet struct {
+	value *StyleSheet
+}
+
+func (o *withStyleSheet) SetConfig(c *renderer.Config) {
+	c.Options[optStyleSheet] = o.value
+}
+
+func (o *withStyleSheet) SetHighlightingOption(c *Config) {
+	c.StyleSheet = o.value
+}
+
+// WithStyleSheet is a functional option that sets a StyleSheet that collects
+// CSS data of styles used in conversions.
+func WithStyleSheet(s *StyleSheet) Option {
+	return &withStyleSheet{s}
+}
+
+// scopeOptions returns chroma HTML formatter options that scope classes of
+// the given style if it is not the default style.
+func (r *HTMLRenderer) scopeOptions(style *chroma.Style) []chromahtml.Option {
+	if r.StyleSheet == nil || style == nil || style == r.defaultStyle() {
+		return nil
+	}
+	return []chromahtml.Option{chromahtml.ClassPrefix(StyleClassPrefix(style))}
+}
+
+// writeCSS writes CSS data for the given formatter and style.
+// d can be nil.
+func (r *HTMLRenderer) writeCSS(formatter *chromahtml.Formatter, style *chroma.Style, d *decorations) error {
+	var extra string
+	prefix := ""
+	if d != nil && formatter.Classes {
+		prefix = classPrefix(formatter)
+		extra = d.cssFor(prefix, style)
+	}
+	if r.CSSWriter != nil {
+		if err := formatter.WriteCSS(r.CSSWriter, style); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(r.CSSWriter, extra); err != nil {
+			return err
+		}
+	}
+	if r.StyleSheet != nil && formatter.Classes {
+		if err := r.StyleSheet.Add(formatter, style); err != nil {
+			return err
+		}
+		r.StyleSheet.AddCSS(extra)
+	}
+	dark := r.darkStyle()
+	if dark == nil || !formatter.Classes || style != r.defaultStyle() {
+		return nil
+	}
+	var light bytes.Buffer
+	if err := formatter.WriteCSS(&light, style); err != nil {
+		return err
+	}
+	light.WriteString(extra)
+	var buffer bytes.Buffer
+	if err := formatter.WriteCSS(&buffer, dark); err != nil {
+		return err
+	}
+	if d != nil {
+		buffer.WriteString(d.cssFor(prefix, dark))
+	}
+	css := r.darkCSS(overrideCSS(light.String(), buffer.String()))
+	if r.CSSWriter != nil {
+		if _, err := io.WriteString(r.CSSWriter, css); err != nil {
+			return err
+		}
+	}
+	if r.StyleSheet != nil {
+		r.StyleSheet.AddCSS(css)
+	}
+	return nil
+}
+
+// classPrefix returns the class prefix of the given formatter.
+func classPrefix(formatter *chromahtml.Formatter) string {
+	var buffer bytes.Buffer
+	_ = formatter.WriteCSS(&buffer, styles.Fallback)
+	// the first rule is "/* Background */ .PREFIXbg { ... }".
+	rule := buffer.String()
+	start := strings.IndexByte(rule, '.')
+	end := strings.Index(rule, "bg {")
+	if start < 0 || end < start {
+		return ""
+	}
+	return rule[start+1 : end]
+}
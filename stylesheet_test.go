@@ -0,0 +1,51 @@
+package highlighting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+func TestStyleSheet(t *testing.T) {
+	styleSheet := NewStyleSheet()
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithStyle("github"),
+				WithStyleSheet(styleSheet),
+				WithFormatOptions(
+					chromahtml.WithClasses(true),
+				),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```go\nx := 1\n```\n\n```go\ny := 2\n```\n\n```go {hl_style=monokai}\nz := 3\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `<pre tabindex="0" class="monokai-chroma"><code><span class="monokai-line"><span class="monokai-cl"><span class="monokai-nx">z</span>`) {
+		t.Errorf("classes of non-default styles should be scoped, got\n%s", buffer.String())
+	}
+	if strings.Count(buffer.String(), `<pre tabindex="0" class="chroma">`) != 2 {
+		t.Errorf("classes of the default style should not be scoped, got\n%s", buffer.String())
+	}
+
+	css := styleSheet.String()
+	for _, rule := range []string{
+		"/* PreWrapper */ .chroma { background-color: #ffffff; }\n",
+		"/* PreWrapper */ .monokai-chroma { color: #f8f8f2; background-color: #272822; }\n",
+		"/* Keyword */ .monokai-chroma .monokai-k { color: #66d9ef }\n",
+	} {
+		if strings.Count(css, rule) != 1 {
+			t.Errorf("%q should be written once, got\n%s", rule, css)
+		}
+	}
+
+	styleSheet.Reset()
+	if styleSheet.String() != "" {
+		t.Errorf("Reset should discard rules, got\n%s", styleSheet.String())
+	}
+}
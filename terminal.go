@@ -0,0 +1,219 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// TerminalColors is a color depth of terminals.
+type TerminalColors int
+
+const (
+	// Terminal8 renders code with 8 colors.
+	Terminal8 TerminalColors = iota
+
+	// Terminal16 renders code with 16 colors.
+	Terminal16
+
+	// Terminal256 renders code with 256 colors.
+	Terminal256
+
+	// TerminalTrueColor renders code with 24-bit colors.
+	TerminalTrueColor
+)
+
+func (c TerminalColors) formatter() chroma.Formatter {
+	switch c {
+	case Terminal8:
+		return formatters.TTY8
+	case Terminal16:
+		return formatters.TTY16
+	case TerminalTrueColor:
+		return formatters.TTY16m
+	default:
+		return formatters.TTY256
+	}
+}
+
+const optTerminalColors renderer.OptionName = "HighlightingTerminalColors"
+
+type withTerminalColors struct {
+	value TerminalColors
+}
+
+func (o *withTerminalColors) SetConfig(c *renderer.Config) {
+	c.Options[optTerminalColors] = o.value
+}
+
+func (o *withTerminalColors) SetHighlightingOption(c *Config) {
+	c.TerminalColors = o.value
+}
+
+// WithTerminalColors is a functional option that sets a color depth used by
+// the TerminalRenderer.
+func WithTerminalColors(colors TerminalColors) Option {
+	return &withTerminalColors{colors}
+}
+
+// TerminalRenderer struct is a renderer.NodeRenderer implementation that
+// renders fenced code blocks with ANSI escape sequences.
+type TerminalRenderer struct {
+	Config
+}
+
+// NewTerminalRenderer builds a new TerminalRenderer with given options and returns it.
+func NewTerminalRenderer(opts ...Option) renderer.NodeRenderer {
+	r := &TerminalRenderer{
+		Config: NewConfig(),
+	}
+	for _, opt := range opts {
+		opt.SetHighlightingOption(&r.Config)
+	}
+	return r
+}
+
+// RegisterFuncs implements NodeRenderer.RegisterFuncs.
+func (r *TerminalRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *TerminalRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.FencedCodeBlock)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	language := fencedCodeBlockLanguage(source, n)
+	var info []byte
+	if n.Info != nil {
+		info = n.Info.Segment.Value(source)
+	}
+	attrs := getAttributes(n, info)
+	settings := r.newCodeBlockSettings(attrs)
+	if settings.err != nil {
+		if err := r.handleError(newHighlightError(opConfigure, language, codeBlockLine(source, n), settings.err)); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+
+	var buffer bytes.Buffer
+	l := n.Lines().Len()
+	for i := 0; i < l; i++ {
+		line := n.Lines().At(i)
+		buffer.Write(line.Value(source))
+	}
+	if settings.highlightMarkers {
+		code, lines := stripHighlightMarkers(buffer.String())
+		buffer.Reset()
+		buffer.WriteString(code)
+		settings.addHighlightLines(lines)
+	}
+	if settings.highlightPattern != nil {
+		settings.addHighlightLines(matchLines(buffer.String(), settings.highlightPattern))
+	}
+
+	var lexer chroma.Lexer
+	if language != nil {
+		lexer = r.getLexer(language)
+	}
+	if lexer == nil {
+		lexer = r.filenameLexer(attrs, "")
+	}
+	if lexer == nil && language != nil && !settings.nohl {
+		r.reportUnknownLanguage(language, codeBlockLine(source, n))
+	}
+	if settings.nohl || (lexer == nil && !r.GuessLanguage) {
+		_, _ = w.Write(buffer.Bytes())
+		return ast.WalkContinue, nil
+	}
+	if lexer == nil {
+		lexer = r.analyseLexer(buffer.String())
+	}
+	style := settings.style
+	if style == nil {
+		style = r.defaultStyle()
+	}
+	if style == nil {
+		style = styles.Fallback
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, buffer.String())
+	if err != nil {
+		if err := r.handleError(newHighlightError(opTokenise, language, codeBlockLine(source, n), err)); err != nil {
+			return ast.WalkStop, err
+		}
+		_, _ = w.Write(buffer.Bytes())
+		return ast.WalkContinue, nil
+	}
+	formatter := r.TerminalColors.formatter()
+	lines := chroma.SplitTokensIntoLines(iterator.Tokens())
+	lineDigits := len(strconv.Itoa(settings.baseLineNumber + len(lines) - 1))
+	var hlStyle *chroma.Style
+	for index, tokens := range lines {
+		line := settings.baseLineNumber + index
+		lineStyle := style
+		if settings.isHighlighted(line) {
+			if hlStyle == nil {
+				hlStyle = lineHighlightStyle(style)
+			}
+			lineStyle = hlStyle
+		}
+		if settings.lineNumbers == chroma.Yes {
+			tokens = append([]chroma.Token{{
+				Type:  chroma.LineNumbers,
+				Value: fmt.Sprintf("%*d ", lineDigits, line),
+			}}, tokens...)
+		}
+		if err := formatter.Format(w, lineStyle, chroma.Literator(tokens...)); err != nil {
+			if err := r.handleError(newHighlightError(opFormat, language, codeBlockLine(source, n), err)); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+// lineHighlightStyle returns a style that has the background color of
+// highlighted lines for all token types.
+func lineHighlightStyle(style *chroma.Style) *chroma.Style {
+	bg := style.Get(chroma.LineHighlight).Background
+	builder := style.Builder()
+	for tt := range chroma.StandardTypes {
+		entry := style.Get(tt)
+		entry.Background = bg
+		builder.AddEntry(tt, entry)
+	}
+	hlStyle, err := builder.Build()
+	if err != nil {
+		return style
+	}
+	return hlStyle
+}
+
+type terminalHighlighting struct {
+	options []Option
+}
+
+// NewTerminalHighlighting returns a new extension that renders fenced code
+// blocks for terminals with given options.
+func NewTerminalHighlighting(opts ...Option) goldmark.Extender {
+	return &terminalHighlighting{
+		options: opts,
+	}
+}
+
+// Extend implements goldmark.Extender.
+func (e *terminalHighlighting) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(NewTerminalRenderer(e.options...), 200),
+	))
+}
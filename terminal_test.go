@@ -0,0 +1,57 @@
+package highlighting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+func TestTerminalHighlighting(t *testing.T) {
+	for i, test := range []struct {
+		colors     TerminalColors
+		attributes string
+		expect     string
+	}{
+		{Terminal256, ``, "x \x1b[1m\x1b[38;5;16m:=\x1b[0m \x1b[38;5;30m1\x1b[0m\n"},
+		{TerminalTrueColor, ``, "x \x1b[1m\x1b[38;2;0;0;0m:=\x1b[0m \x1b[38;2;0;153;153m1\x1b[0m\n"},
+		{Terminal256, `{linenos=true,linenostart=9}`, "9 x \x1b[1m\x1b[38;5;16m:=\x1b[0m \x1b[38;5;30m1\x1b[0m\n"},
+		{Terminal256, `{hl_lines=[1]}`, "\x1b[48;5;254mx\x1b[0m\x1b[48;5;254m \x1b[0m\x1b[1m\x1b[38;5;16m\x1b[48;5;254m:=\x1b[0m\x1b[48;5;254m \x1b[0m\x1b[38;5;30m\x1b[48;5;254m1\x1b[0m\x1b[48;5;254m\n\x1b[0m"},
+		{Terminal256, `{nohl=true}`, "x := 1\n"},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			markdown := goldmark.New(
+				goldmark.WithExtensions(
+					NewTerminalHighlighting(
+						WithStyle("github"),
+						WithTerminalColors(test.colors),
+					),
+				),
+			)
+			var buffer bytes.Buffer
+			if err := markdown.Convert([]byte("```go "+test.attributes+"\nx := 1\n```\n"), &buffer); err != nil {
+				t.Fatal(err)
+			}
+			if buffer.String() != test.expect {
+				t.Errorf("render mismatch, got\n%q\nexpected\n%q", buffer.String(), test.expect)
+			}
+		})
+	}
+}
+
+func TestTerminalHighlightingUnknownLanguage(t *testing.T) {
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewTerminalHighlighting(),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```unknown\na < b\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buffer.String()) != "a < b" {
+		t.Errorf("render mismatch, got\n%q", buffer.String())
+	}
+}
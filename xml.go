@@ -0,0 +1,135 @@
+package highlighting
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark/renderer"
+)
+
+// LoadLexers loads chroma XML lexer definitions that match the given
+// pattern(see fs.Glob) from the given file system.
+// Returned lexers can be used with WithLexers.
+func LoadLexers(fsys fs.FS, pattern string) ([]chroma.Lexer, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]chroma.Lexer, 0, len(paths))
+	for _, path := range paths {
+		lexer, err := chroma.NewXMLLexer(fsys, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		// rules are loaded lazily, validates them here.
+		if _, err := lexer.Rules(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		result = append(result, lexer)
+	}
+	return result, nil
+}
+
+type xmlStyle struct {
+	Name    string          `xml:"name,attr"`
+	Entries []xmlStyleEntry `xml:"entry"`
+}
+
+type xmlStyleEntry struct {
+	Type  string `xml:"type,attr"`
+	Style string `xml:"style,attr"`
+}
+
+// LoadStyles loads chroma XML style definitions that match the given
+// pattern(see fs.Glob) from the given file system.
+// Returned styles can be used with WithStyles.
+//
+// A style definition looks like the following:
+//
+//	<style name="mystyle">
+//	  <entry type="Background" style="bg:#ffffff"/>
+//	  <entry type="Keyword" style="bold #000080"/>
+//	</style>
+func LoadStyles(fsys fs.FS, pattern string) ([]*chroma.Style, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	tokenTypes := map[string]chroma.TokenType{}
+	for tt := range chroma.StandardTypes {
+		tokenTypes[tt.String()] = tt
+	}
+	result := make([]*chroma.Style, 0, len(paths))
+	for _, path := range paths {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		var def xmlStyle
+		if err := xml.Unmarshal(data, &def); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(def.Name) == 0 {
+			return nil, fmt.Errorf("%s: style name is required", path)
+		}
+		entries := chroma.StyleEntries{}
+		for _, entry := range def.Entries {
+			tt, ok := tokenTypes[entry.Type]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown token type %q", path, entry.Type)
+			}
+			entries[tt] = entry.Style
+		}
+		style, err := chroma.NewStyle(def.Name, entries)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		result = append(result, style)
+	}
+	return result, nil
+}
+
+// getStyle returns a style that has the given name. If no styles are found,
+// this returns the chroma fallback style.
+func (c *Config) getStyle(name string) *chroma.Style {
+	if style, ok := c.Styles[strings.ToLower(name)]; ok {
+		return style
+	}
+	return styles.Get(name)
+}
+
+const optStyles renderer.OptionName = "HighlightingStyles"
+
+type withStyles struct {
+	value []*chroma.Style
+}
+
+func (o *withStyles) SetConfig(c *renderer.Config) {
+	if _, ok := c.Options[optStyles]; !ok {
+		c.Options[optStyles] = map[string]*chroma.Style{}
+	}
+	m := c.Options[optStyles].(map[string]*chroma.Style)
+	for _, style := range o.value {
+		m[strings.ToLower(style.Name)] = style
+	}
+}
+
+func (o *withStyles) SetHighlightingOption(c *Config) {
+	if c.Styles == nil {
+		c.Styles = map[string]*chroma.Style{}
+	}
+	for _, style := range o.value {
+		c.Styles[strings.ToLower(style.Name)] = style
+	}
+}
+
+// WithStyles is a functional option that adds styles private to the
+// extension. These styles can be referred by their names from WithStyle,
+// WithDarkStyle and the hl_style attribute.
+func WithStyles(s ...*chroma.Style) Option {
+	return &withStyles{s}
+}
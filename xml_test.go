@@ -0,0 +1,84 @@
+package highlighting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+)
+
+var testXMLFS = fstest.MapFS{
+	"lexers/mydsl.xml": &fstest.MapFile{Data: []byte(`<lexer>
+  <config>
+    <name>MyXMLDSL</name>
+    <alias>xmldsl</alias>
+  </config>
+  <rules>
+    <state name="root">
+      <rule pattern="\bdo\b">
+        <token type="Keyword"/>
+      </rule>
+      <rule pattern=".|\n">
+        <token type="Text"/>
+      </rule>
+    </state>
+  </rules>
+</lexer>`)},
+	"styles/mystyle.xml": &fstest.MapFile{Data: []byte(`<style name="MyStyle">
+  <entry type="Background" style="bg:#ffffff"/>
+  <entry type="Keyword" style="bold #000080"/>
+</style>`)},
+	"broken/style.xml": &fstest.MapFile{Data: []byte(`<style name="broken">
+  <entry type="NoSuchType" style="bold"/>
+</style>`)},
+}
+
+func TestLoadXMLDefinitions(t *testing.T) {
+	lexers, err := LoadLexers(testXMLFS, "lexers/*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	styles, err := LoadStyles(testXMLFS, "styles/*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithLexers(lexers...),
+				WithStyles(styles...),
+				WithStyle("mystyle"),
+			),
+		),
+	)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte("```xmldsl\ndo it\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `<span style="color:#000080;font-weight:bold">do</span> it`) {
+		t.Errorf("XML definitions should be used, got\n%s", buffer.String())
+	}
+
+	buffer.Reset()
+	markdown = goldmark.New(
+		goldmark.WithExtensions(
+			NewHighlighting(
+				WithStyles(styles...),
+				WithFormatOptions(chromahtml.WithClasses(false)),
+			),
+		),
+	)
+	if err := markdown.Convert([]byte("```go {hl_style=MyStyle}\nfunc f()\n```\n"), &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), `<span style="color:#000080;font-weight:bold">func</span>`) {
+		t.Errorf("XML styles should be used by hl_style, got\n%s", buffer.String())
+	}
+
+	if _, err := LoadStyles(testXMLFS, "broken/*.xml"); err == nil || !strings.Contains(err.Error(), "NoSuchType") {
+		t.Errorf("unknown token types should be an error, got %v", err)
+	}
+}